@@ -1,30 +1,71 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
 	"collector/pkg/config"
 
 	"github.com/alexflint/go-arg"
+	"golang.org/x/term"
 )
 
 const resultZip = "aci-vetr-data.zip"
 
+// aggregateZip is the multi-fabric archive bundling the fabric archives.
+const aggregateZip = "aci-collection.zip"
+
+// logName is the name of the log in the archives, wherever the log file is written.
+const logName = "aci-vetr.log"
+
 var version = "(dev)"
 
 // Args are command line parameters.
 type Args struct {
-	URL               string            `arg:"--url,env:ACI_URL"           help:"APIC hostname or IP address"`
-	Username          string            `arg:"--username,env:ACI_USERNAME" help:"APIC username"`
-	Password          string            `arg:"--password,env:ACI_PASSWORD" help:"APIC password"`
-	Output            string            `arg:"-o"                          help:"Output file"`
-	ConfigFile        string            `arg:"-c,--config"                 help:"Path to YAML configuration file"`
-	RequestRetryCount int               `arg:"--request-retry-count"       help:"Times to retry a failed request"       default:"3"`
-	RetryDelay        int               `arg:"--retry-delay"               help:"Seconds to wait before retry"          default:"10"`
-	BatchSize         int               `arg:"--batch-size"                help:"Max request to send in parallel"       default:"7"`
-	PageSize          int               `arg:"--page-size"                 help:"Object per page for large datasets"    default:"1000"`
-	Confirm           bool              `arg:"-y"                          help:"Skip confirmation"`
-	Verbose           bool              `arg:"-v,--verbose"                help:"Enable verbose (debug level) logging"`
-	Class             string            `arg:"--class"                     help:"Collect a single class"                default:"all"`
-	Query             map[string]string `arg:"-q"                          help:"Query(s) to filter single class query"`
+	URL                  string            `arg:"--url,env:ACI_URL"                     help:"APIC hostname or IP address; separate APICs of a cluster with commas"`
+	Username             string            `arg:"--username,env:ACI_USERNAME"           help:"APIC username"`
+	Password             string            `arg:"--password,env:ACI_PASSWORD"           help:"APIC password"`
+	PasswordFile         string            `arg:"--password-file,env:ACI_PASSWORD_FILE" help:"File holding the APIC password, readable only by its owner"`
+	PasswordCommand      string            `arg:"--password-command"                    help:"Command printing the APIC password, e.g. a vault CLI"`
+	Netrc                bool              `arg:"--netrc"                               help:"Look up missing credentials by APIC address in ~/.netrc or $NETRC"`
+	PrivateKey           string            `arg:"--private-key,env:ACI_PRIVATE_KEY"     help:"Path to PEM private key for certificate-based authentication"`
+	CertName             string            `arg:"--cert-name,env:ACI_CERT_NAME"         help:"Name of the APIC user certificate for the private key"`
+	TLSVerify            bool              `arg:"--tls-verify"                          help:"Verify the APIC certificate"`
+	CABundle             string            `arg:"--ca-bundle"                           help:"PEM file of CA certificates used to verify the APIC"`
+	TLSFingerprint       string            `arg:"--tls-fingerprint"                     help:"SHA-256 fingerprints the APIC certificates must match, separated by commas"`
+	TLSMinVersion        string            `arg:"--tls-min-version"                     help:"Minimum TLS version, e.g. 1.2"`
+	TLSTrustOnFirstUse   bool              `arg:"--tls-tofu"                            help:"Trust and pin the APIC certificate on first use"`
+	DiscoverCluster      bool              `arg:"--discover-cluster"                    help:"Discover the other APICs of the cluster for failover"`
+	Output               string            `arg:"-o"                                    help:"Output file, default aci-vetr-data.zip"`
+	ConfigFile           string            `arg:"-c,--config"                           help:"Path to YAML configuration file"`
+	Fabric               string            `arg:"--fabric"                              help:"Comma-separated names of the fabrics of the config file to collect; globs like dc-* are allowed"`
+	Tag                  string            `arg:"--tag"                                 help:"Comma-separated tags of the fabrics of the config file to collect; globs are allowed"`
+	ExcludeTag           string            `arg:"--exclude-tag"                         help:"Comma-separated tags of the fabrics of the config file to skip; globs are allowed"`
+	RequestRetryCount    *int              `arg:"--request-retry-count"                 help:"Times to retry a failed request, default 3"`
+	RetryDelay           *int              `arg:"--retry-delay"                         help:"Seconds to wait before retry, default 10"`
+	MaxRetryDelay        *int              `arg:"--max-retry-delay"                     help:"Max seconds to wait before retry, default 120"`
+	BatchSize            *int              `arg:"--batch-size"                          help:"Max request to send in parallel, default 7"`
+	AdaptiveConcurrency  bool              `arg:"--adaptive"                            help:"Adapt the number of parallel requests to APIC load"`
+	MaxConcurrency       *int              `arg:"--max-concurrency"                     help:"Max requests in parallel in adaptive mode, default 32"`
+	MaxRequestsPerSecond *float64          `arg:"--max-rps"                             help:"Max requests per second, 0 for no limit"`
+	PageSize             *int              `arg:"--page-size"                           help:"Object per page for large datasets, default 1000"`
+	Priority             string            `arg:"--priority"                            help:"Comma-separated classes to fetch first"`
+	Confirm              bool              `arg:"-y"                                    help:"Skip confirmation"`
+	Verbose              bool              `arg:"-v,--verbose"                          help:"Enable verbose (debug level) logging"`
+	Class                string            `arg:"--class"                               help:"Collect a single class, default all"`
+	Query                map[string]string `arg:"-q"                                    help:"Query(s) to filter single class query"`
+	Deadline             time.Duration     `arg:"--deadline"                            help:"Overall time budget, e.g. 90m; data collected by then is kept"`
+	Resume               bool              `arg:"--resume"                              help:"Resume an interrupted collection from its checkpoint"`
+	LogFile              string            `arg:"--log-file"                            help:"Log file, default aci-vetr.log"`
+	JSON                 bool              `arg:"--json"                                help:"Print a JSON summary of the run to stdout"`
+	PrintEffectiveConfig bool              `arg:"--print-effective-config"              help:"Print the settings of each fabric after applying the command line, with secrets masked, and exit"`
+	MaxParallelFabrics   *int              `arg:"--max-parallel-fabrics"                help:"Max fabrics of a config file collected in parallel, 0 for no limit"`
+	RequestBudget        *int              `arg:"--request-budget"                      help:"Max requests in flight across all fabrics, 0 for no limit"`
+	FabricStartDelay     time.Duration     `arg:"--fabric-start-delay"                  help:"Time between the starts of two fabrics, e.g. 10s"`
+	Config               *ConfigCmd        `arg:"subcommand:config"                     help:"Work with config files: convert an inventory, validate, print the JSON Schema"`
+	NonInteractive       bool              `arg:"--non-interactive"                     help:"Fail on missing values instead of prompting; default when stdin is not a terminal"`
 }
 
 // Description is the CLI description string.
@@ -38,48 +79,226 @@ func (Args) Version() string {
 }
 
 // readArgs collects the CLI args and returns a config.Config.
+// Command line flags, and the environment variables standing in for them, take
+// precedence over the settings of the config file.
+// On errors the config is returned as far as it was read, so that its log file can be used.
 func readArgs() (*config.Config, error) {
-	args := Args{Output: resultZip}
-	arg.MustParse(&args)
+	var args Args
+	parser := arg.MustParse(&args)
+	if args.Config != nil {
+		if parser.Subcommand() == args.Config {
+			parser.FailSubcommand("missing subcommand", "config")
+		}
+		os.Exit(runConfig(args.Config))
+	}
 
+	cfg := config.New()
+	// The log file flag also applies when reading the configuration fails
+	defer func() {
+		if args.LogFile != "" {
+			cfg.Global.LogFile = args.LogFile
+		}
+	}()
 	if args.ConfigFile != "" {
-		cfg, err := config.ParseConfig(args.ConfigFile)
+		parsed, err := config.ParseConfig(args.ConfigFile)
 		if err != nil {
-			return nil, err
+			return &cfg, err
 		}
-		if err := cfg.NormalizeAndPrompt(); err != nil {
-			return nil, err
+		cfg = *parsed
+		// Select before prompting, so that only the selected fabrics need credentials
+		if err := cfg.Select(args.selector()); err != nil {
+			return &cfg, err
 		}
-		return cfg, nil
+	} else {
+		if !args.selector().Empty() {
+			return &cfg, fmt.Errorf("--fabric, --tag and --exclude-tag select fabrics of a config file")
+		}
+		cfg.Fabrics = []config.FabricConfig{{Output: resultZip}}
+	}
+	if err := args.apply(&cfg); err != nil {
+		return &cfg, err
+	}
+	// Flags and environment variables are not checked with the config file
+	if err := cfg.Check(); err != nil {
+		return &cfg, err
 	}
 
-	cfg := config.New()
-	requestRetryCount := args.RequestRetryCount
-	retryDelay := args.RetryDelay
-	batchSize := args.BatchSize
-	pageSize := args.PageSize
-	confirm := args.Confirm
-	verbose := args.Verbose
-
-	cfg.Global.Verbose = args.Verbose
-	cfg.Fabrics = []config.FabricConfig{{
-		URL:               args.URL,
-		Output:            args.Output,
-		Username:          args.Username,
-		Password:          args.Password,
-		RequestRetryCount: &requestRetryCount,
-		RetryDelay:        &retryDelay,
-		BatchSize:         &batchSize,
-		PageSize:          &pageSize,
-		Confirm:           &confirm,
-		Verbose:           &verbose,
-		Class:             args.Class,
-		Query:             args.Query,
-	}}
+	if args.PrintEffectiveConfig {
+		if err := cfg.WriteEffective(os.Stdout); err != nil {
+			return &cfg, err
+		}
+		os.Exit(exitOK)
+	}
 
 	if err := cfg.NormalizeAndPrompt(); err != nil {
-		return nil, err
+		return &cfg, err
 	}
-
 	return &cfg, nil
 }
+
+// selector returns the fabrics of the config file to collect.
+func (args Args) selector() config.Selector {
+	return config.Selector{
+		Names:       splitList(args.Fabric),
+		Tags:        splitList(args.Tag),
+		ExcludeTags: splitList(args.ExcludeTag),
+	}
+}
+
+// apply overrides the settings of the config with the flags set on the command line.
+// Fabric settings are applied to every fabric; settings that identify a fabric,
+// such as its URL, only to a config with a single fabric.
+func (args Args) apply(cfg *config.Config) error {
+	// Settings identifying a fabric
+	fabricOnly := []struct {
+		flag string
+		set  bool
+	}{
+		{"--url", args.URL != ""},
+		{"--output", args.Output != ""},
+		{"--tls-fingerprint", args.TLSFingerprint != ""},
+	}
+	for _, f := range fabricOnly {
+		if f.set && len(cfg.Fabrics) > 1 {
+			return fmt.Errorf("%s can't be used with a config file of %d fabrics", f.flag, len(cfg.Fabrics))
+		}
+	}
+
+	// Additional comma-separated APICs are failover targets
+	if urls := splitList(args.URL); len(urls) > 0 {
+		cfg.Fabrics[0].URL, cfg.Fabrics[0].URLs = urls[0], urls[1:]
+	}
+	if args.Output != "" {
+		cfg.Fabrics[0].Output = args.Output
+	}
+	if args.TLSFingerprint != "" {
+		cfg.Fabrics[0].TLSFingerprint = args.TLSFingerprint
+	}
+
+	enabled := true
+	priority := splitList(args.Priority)
+	for i := range cfg.Fabrics {
+		fabric := &cfg.Fabrics[i]
+		if args.Username != "" {
+			fabric.Username = args.Username
+		}
+		// A password source on the command line replaces those of the config file
+		if args.Password != "" || args.PasswordFile != "" || args.PasswordCommand != "" {
+			fabric.Password = args.Password
+			fabric.PasswordFile = args.PasswordFile
+			fabric.PasswordCommand = args.PasswordCommand
+		}
+		if args.PrivateKey != "" {
+			fabric.PrivateKey = args.PrivateKey
+		}
+		if args.CertName != "" {
+			fabric.CertName = args.CertName
+		}
+		if args.TLSVerify {
+			fabric.TLSVerify = &enabled
+		}
+		if args.CABundle != "" {
+			fabric.CABundle = args.CABundle
+		}
+		if args.TLSMinVersion != "" {
+			fabric.TLSMinVersion = args.TLSMinVersion
+		}
+		if args.TLSTrustOnFirstUse {
+			fabric.TLSTrustOnFirstUse = &enabled
+		}
+		if args.DiscoverCluster {
+			fabric.DiscoverCluster = &enabled
+		}
+		if args.RequestRetryCount != nil {
+			fabric.RequestRetryCount = args.RequestRetryCount
+		}
+		if args.RetryDelay != nil {
+			fabric.RetryDelay = args.RetryDelay
+		}
+		if args.MaxRetryDelay != nil {
+			fabric.MaxRetryDelay = args.MaxRetryDelay
+		}
+		if args.BatchSize != nil {
+			fabric.BatchSize = args.BatchSize
+		}
+		if args.AdaptiveConcurrency {
+			fabric.AdaptiveConcurrency = &enabled
+		}
+		if args.MaxConcurrency != nil {
+			fabric.MaxConcurrency = args.MaxConcurrency
+		}
+		if args.MaxRequestsPerSecond != nil {
+			fabric.MaxRequestsPerSecond = args.MaxRequestsPerSecond
+		}
+		if args.PageSize != nil {
+			fabric.PageSize = args.PageSize
+		}
+		if priority != nil {
+			fabric.Priority = priority
+		}
+		if args.Confirm {
+			fabric.Confirm = &enabled
+		}
+		if args.Verbose {
+			fabric.Verbose = &enabled
+		}
+		if args.Class != "" {
+			fabric.Class = args.Class
+		}
+		if args.Query != nil {
+			fabric.Query = args.Query
+		}
+	}
+
+	// Global settings
+	if args.Confirm {
+		cfg.Global.Confirm = true
+	}
+	if args.Verbose {
+		cfg.Global.Verbose = true
+	}
+	if args.Netrc {
+		cfg.Global.Netrc = true
+	}
+	if args.Deadline != 0 {
+		cfg.Global.Deadline = args.Deadline
+	}
+	if args.Resume {
+		cfg.Global.Resume = true
+	}
+	if args.LogFile != "" {
+		cfg.Global.LogFile = args.LogFile
+	}
+	if args.JSON {
+		cfg.Global.JSON = true
+	}
+	if args.MaxParallelFabrics != nil {
+		cfg.Global.MaxParallelFabrics = *args.MaxParallelFabrics
+	}
+	if args.RequestBudget != nil {
+		cfg.Global.RequestBudget = *args.RequestBudget
+	}
+	if args.FabricStartDelay != 0 {
+		cfg.Global.FabricStartDelay = args.FabricStartDelay
+	}
+	if args.NonInteractive || !stdinIsTerminal() {
+		cfg.Global.NonInteractive = true
+	}
+	return nil
+}
+
+// splitList splits a comma-separated argument, ignoring empty entries.
+func splitList(arg string) []string {
+	var list []string
+	for _, item := range strings.Split(arg, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// stdinIsTerminal reports whether someone may be there to answer prompts.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
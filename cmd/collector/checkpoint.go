@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+
+	"collector/pkg/archive"
+	"collector/pkg/cli"
+	"collector/pkg/config"
+	"collector/pkg/log"
+)
+
+// checkpoint records the requests completed for an archive so that an interrupted
+// collection can be resumed. It is kept next to the archive as <output>.checkpoint.json
+// until a collection completes without errors.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	// Version is the collector version that wrote the checkpoint.
+	Version string `json:"version"`
+	// Params are the settings that determine what is collected.
+	Params checkpointParams `json:"params"`
+	// Completed maps each completed class to the archive files written for it.
+	Completed map[string]completedClass `json:"completed"`
+}
+
+// completedClass is a class collected into the archive, with the files and
+// counts to report for it when the collection is resumed.
+type completedClass struct {
+	Files      []string `json:"files"`
+	Objects    int      `json:"objects"`
+	Pages      int      `json:"pages"`
+	Bytes      int64    `json:"bytes"`
+	DurationMs int64    `json:"durationMs"`
+}
+
+// UnmarshalJSON also reads the list of files written by earlier versions.
+func (c *completedClass) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Files); err == nil {
+		return nil
+	}
+	type plain completedClass
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// checkpointParams must match for a collection to be resumed.
+type checkpointParams struct {
+	URL   string            `json:"url"`
+	Class string            `json:"class"`
+	Query map[string]string `json:"query,omitempty"`
+}
+
+func newCheckpoint(cfg config.FabricConfig) *checkpoint {
+	return &checkpoint{
+		path:    cfg.GetOutputFileName() + ".checkpoint.json",
+		Version: version,
+		Params: checkpointParams{
+			URL:   cfg.GetURLs()[0],
+			Class: cfg.GetClass(),
+			Query: cfg.Query,
+		},
+		Completed: map[string]completedClass{},
+	}
+}
+
+// load reads a previous checkpoint and verifies it was written for the same settings.
+func (c *checkpoint) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	var prev checkpoint
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return fmt.Errorf("invalid checkpoint %s: %w", c.path, err)
+	}
+	if prev.Params.URL != c.Params.URL ||
+		prev.Params.Class != c.Params.Class ||
+		!maps.Equal(prev.Params.Query, c.Params.Query) {
+		return fmt.Errorf("checkpoint %s was written for different settings; "+
+			"remove it or run without --resume to start over", c.path)
+	}
+	if prev.Version != c.Version {
+		log.Warn().Msgf("Resuming a collection started with collector version %s", prev.Version)
+	}
+	if prev.Completed != nil {
+		c.Completed = prev.Completed
+	}
+	return nil
+}
+
+// classFiles matches the archive files of a class: <class>.json, or
+// <class>-N.json and <class>.pages.json when paginated.
+func classFiles(class string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(class) + `(-\d+|\.pages)?\.json$`)
+}
+
+// complete records a completed class with its files and counts.
+func (c *checkpoint) complete(class string, names []string, res cli.Result) error {
+	files := classFiles(class)
+	var written []string
+	for _, name := range names {
+		if files.MatchString(name) {
+			written = append(written, name)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Completed[class] = completedClass{
+		Files:      written,
+		Objects:    res.Objects,
+		Pages:      res.Pages,
+		Bytes:      res.Bytes,
+		DurationMs: res.Duration.Milliseconds(),
+	}
+	return c.save()
+}
+
+// done returns a class completed by a previous run, if any.
+func (c *checkpoint) done(class string) (completedClass, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	completed, ok := c.Completed[class]
+	return completed, ok
+}
+
+// save writes the checkpoint, replacing the previous one atomically.
+func (c *checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// remove deletes the checkpoint once there is nothing left to resume.
+func (c *checkpoint) remove() error {
+	err := os.Remove(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// recordingWriter remembers the names of the files added to an archive.
+type recordingWriter struct {
+	archive.Writer
+	mu    sync.Mutex
+	names []string
+}
+
+// Add adds a file to the archive and records its name.
+func (w *recordingWriter) Add(name string, content []byte) error {
+	if err := w.Writer.Add(name, content); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.names = append(w.names, name)
+	return nil
+}
+
+// added returns the names of the files added so far.
+func (w *recordingWriter) added() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.names)
+}
+
+// openArchive creates the output archive of a fabric and its checkpoint.
+// With resume, the files of classes completed by a previous run are carried over
+// from the existing archive, and those classes are marked done in the checkpoint.
+// Classes whose files are missing from the archive are collected again.
+func openArchive(cfg config.FabricConfig, resume bool, logger log.Logger) (*recordingWriter, *checkpoint, error) {
+	outputFile := cfg.GetOutputFileName()
+	cp := newCheckpoint(cfg)
+	if !resume {
+		// A stale checkpoint or partially resumed archive doesn't describe the new archive
+		if err := cp.remove(); err != nil {
+			return nil, nil, err
+		}
+		if err := os.Remove(outputFile + ".resume"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		arc, err := archive.NewWriter(outputFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &recordingWriter{Writer: arc}, cp, nil
+	}
+
+	_, statErr := os.Stat(outputFile)
+	_, resumeErr := os.Stat(outputFile + ".resume")
+	err := cp.load()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.Info().Msgf("No checkpoint found for %s, starting a new collection", outputFile)
+		return openArchive(cfg, false, logger)
+	case err != nil:
+		return nil, nil, err
+	case statErr != nil && resumeErr != nil:
+		logger.Warn().Msgf("Archive %s not found, starting a new collection", outputFile)
+		cp.Completed = map[string]completedClass{}
+		arc, err := archive.NewWriter(outputFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &recordingWriter{Writer: arc}, cp, nil
+	}
+
+	// Keep the classes whose files are all in the archive
+	var kept []string
+	arc, err := archive.Resume(outputFile, func(names []string) []string {
+		existing := make(map[string]bool)
+		for _, name := range names {
+			existing[name] = true
+		}
+		for class, completed := range cp.Completed {
+			complete := true
+			for _, file := range completed.Files {
+				complete = complete && existing[file]
+			}
+			if !complete {
+				logger.Warn().Msgf("Files of %s missing from archive, collecting it again", class)
+				delete(cp.Completed, class)
+				continue
+			}
+			kept = append(kept, completed.Files...)
+		}
+		return kept
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Info().Msgf("Resuming collection with %d completed classes", len(cp.Completed))
+	return &recordingWriter{Writer: arc, names: kept}, cp, cp.save()
+}
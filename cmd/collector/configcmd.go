@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"collector/pkg/config"
+	"collector/pkg/log"
+)
+
+// ConfigCmd is the config subcommand, for working with config files.
+type ConfigCmd struct {
+	Convert  *ConvertCmd  `arg:"subcommand:convert"  help:"Convert a CSV or Ansible inventory into a config file"`
+	Validate *ValidateCmd `arg:"subcommand:validate" help:"Check config files for unknown settings and invalid values"`
+	Schema   *struct{}    `arg:"subcommand:schema"   help:"Print the JSON Schema of the config file"`
+}
+
+// ValidateCmd checks config files without collecting.
+type ValidateCmd struct {
+	Files []string `arg:"positional,required" help:"Config files to check"`
+}
+
+// runConfig runs a config subcommand and returns the exit code.
+func runConfig(cmd *ConfigCmd) int {
+	switch {
+	case cmd.Convert != nil:
+		return runConvert(cmd.Convert)
+	case cmd.Validate != nil:
+		return runValidate(cmd.Validate)
+	}
+	os.Stdout.Write(config.Schema)
+	return exitOK
+}
+
+// runValidate reports every problem of the config files.
+func runValidate(cmd *ValidateCmd) int {
+	code := exitOK
+	for _, path := range cmd.Files {
+		cfg, err := config.Validate(path)
+		var invalid *config.ValidationError
+		switch {
+		case errors.As(err, &invalid):
+			for _, problem := range invalid.Problems {
+				log.Error().Msg(problem.String())
+			}
+			log.Error().Msgf("%s has %d problem(s).", path, len(invalid.Problems))
+			code = exitFailed
+		case err != nil:
+			log.Error().Err(err).Msgf("Error reading config file %s.", path)
+			code = exitFailed
+		default:
+			log.Info().Msgf("%s is valid, %d fabric(s).", path, len(cfg.Fabrics))
+		}
+	}
+	return code
+}
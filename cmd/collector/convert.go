@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"collector/pkg/config"
+	"collector/pkg/log"
+)
+
+// ConvertCmd converts an inventory into a config file.
+type ConvertCmd struct {
+	Inventory string `arg:"positional,required" help:"CSV file or Ansible inventory in YAML or INI format"`
+	Output    string `arg:"positional"          help:"Config file to write, default stdout"`
+	Format    string `arg:"--format"            help:"csv or ansible, default csv for .csv files and ansible otherwise"`
+	Group     string `arg:"--group"             help:"Ansible group of the fabrics, default all"`
+}
+
+// runConvert writes the config file equivalent to an inventory.
+func runConvert(cmd *ConvertCmd) int {
+	format := cmd.Format
+	if format == "" {
+		format = "ansible"
+		if strings.HasSuffix(strings.ToLower(cmd.Inventory), ".csv") {
+			format = "csv"
+		}
+	}
+
+	var fabrics []config.FabricConfig
+	var err error
+	switch format {
+	case "csv":
+		if cmd.Group != "" {
+			log.Error().Msg("--group only applies to Ansible inventories.")
+			return exitFailed
+		}
+		fabrics, err = config.ParseCSV(cmd.Inventory)
+	case "ansible":
+		fabrics, err = config.ParseAnsibleInventory(cmd.Inventory, cmd.Group)
+	default:
+		log.Error().Msgf("Unknown inventory format %s, use csv or ansible.", format)
+		return exitFailed
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading inventory.")
+		return exitFailed
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Converted from %s by collector config convert\n", cmd.Inventory)
+	cfg := config.Config{Fabrics: fabrics}
+	if err := cfg.Write(&buf); err != nil {
+		log.Error().Err(err).Msg("Error writing config.")
+		return exitFailed
+	}
+	if cmd.Output == "" {
+		os.Stdout.Write(buf.Bytes())
+		return exitOK
+	}
+	// The inventory may hold passwords
+	if err := os.WriteFile(cmd.Output, buf.Bytes(), 0o600); err != nil {
+		log.Error().Err(err).Msgf("Error writing config file %s.", cmd.Output)
+		return exitFailed
+	}
+	log.Info().Msgf("Wrote %d fabric(s) to %s", len(fabrics), cmd.Output)
+	return exitOK
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"collector/pkg/archive"
+	"collector/pkg/log"
+)
+
+// interruptGrace is how long in-flight requests may run after an interrupt.
+const interruptGrace = 60 * time.Second
+
+var (
+	errInterrupted = errors.New("interrupted")
+	errDeadline    = errors.New("deadline reached")
+)
+
+// handleSignals stops scheduling new requests on the first SIGINT/SIGTERM and
+// cancels in-flight requests after interruptGrace.
+// A second signal exits immediately.
+func handleSignals(stopScheduling context.CancelCauseFunc, abort context.CancelCauseFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	<-sigs
+	log.Warn().Msgf("Interrupted. Finishing in-flight requests for up to %s, press Ctrl-C again to abort.",
+		interruptGrace)
+	stopScheduling(errInterrupted)
+	time.AfterFunc(interruptGrace, func() { abort(errInterrupted) })
+
+	<-sigs
+	log.Error().Msg("Aborted.")
+	os.Exit(exitAborted)
+}
+
+// collectionStatus records which requests of a fabric were collected.
+// It is written to the archive as status.json when a collection is stopped early.
+type collectionStatus struct {
+	mu        sync.Mutex
+	Stopped   string            `json:"stopped"`
+	Collected []string          `json:"collected"`
+	Failed    map[string]string `json:"failed"`
+	Pending   []string          `json:"pending"`
+}
+
+func newCollectionStatus() *collectionStatus {
+	return &collectionStatus{
+		Collected: []string{},
+		Failed:    map[string]string{},
+		Pending:   []string{},
+	}
+}
+
+// record stores the outcome of a request.
+func (s *collectionStatus) record(class string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.Failed[class] = err.Error()
+		return
+	}
+	s.Collected = append(s.Collected, class)
+}
+
+// skip marks a request that was never started.
+func (s *collectionStatus) skip(class string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Pending = append(s.Pending, class)
+}
+
+// write adds the status record to the archive.
+func (s *collectionStatus) write(arc archive.Writer, reason error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Stopped = reason.Error()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return arc.Add("status.json", content)
+}
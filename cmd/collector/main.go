@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"collector/pkg/aci"
 	"collector/pkg/archive"
@@ -13,7 +19,6 @@ import (
 	"collector/pkg/req"
 
 	"github.com/rs/zerolog"
-	"golang.org/x/sync/errgroup"
 )
 
 func pause(msg string) {
@@ -23,7 +28,26 @@ func pause(msg string) {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run collects the configured fabrics and returns the exit code.
+func run() int {
+	// The configuration names the log file, so events logged while reading it
+	// are captured and written to the log file once it is open
+	early := log.StartCapture("")
 	cfg, err := readArgs()
+	if err := log.SetFileFrom(cfg.Global.LogFile, early); err != nil {
+		log.Fatal().Err(err).Msgf("Error creating log file: %s.", cfg.Global.LogFile)
+	}
+
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		for _, problem := range invalid.Problems {
+			log.Error().Msg(problem.String())
+		}
+		log.Fatal().Msg("Error reading configuration.")
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error reading configuration.")
 	}
@@ -35,34 +59,57 @@ func main() {
 		log.SetLevel(zerolog.InfoLevel)
 	}
 
+	// ctx cancels in-flight requests, stop only prevents new requests from being scheduled.
+	// The deadline bounds the whole run, after which collected data is finalized.
+	ctx, abort := context.WithCancelCause(context.Background())
+	defer abort(nil)
+	if cfg.Global.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.Global.Deadline, errDeadline)
+		defer cancel()
+	}
+	stop, stopScheduling := context.WithCancelCause(ctx)
+	defer stopScheduling(nil)
+	go handleSignals(stopScheduling, abort)
+
 	if len(cfg.Fabrics) > 1 {
-		runMultiFabric(cfg)
-		return
+		return runMultiFabric(ctx, stop, cfg)
 	}
 
-	runSingleFabric(cfg)
+	return runSingleFabric(ctx, stop, cfg)
 }
 
-func runSingleFabric(cfg *config.Config) {
+func runSingleFabric(ctx, stop context.Context, cfg *config.Config) int {
+	start := time.Now()
 	fabric := cfg.Fabrics[0].MergeWithGlobal(cfg.Global)
+	logger := log.New()
+	capture := log.StartCapture("")
+	defer capture.Stop()
+	failed := func(err error) int {
+		result := fabricResult{fabric: fabric.GetFabricName(), err: err, duration: time.Since(start)}
+		return finish(cfg, start, []fabricResult{result}, "", fabric.GetConfirm())
+	}
 
 	// Initialize ACI HTTP client
-	client, err := cli.GetClient(fabric)
+	client, err := cli.GetClient(ctx, fabric)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing ACI client.")
+		log.Error().Err(err).Msg("Error initializing ACI client.")
+		return failed(err)
 	}
 
-	// Create results archive
-	outputFile := fabric.GetOutputFileName()
-	arc, err := archive.NewWriter(outputFile)
+	// Initiate requests
+	reqs, err := req.GetRequests()
 	if err != nil {
-		log.Fatal().Err(err).Msgf("Error creating archive file: %s.", outputFile)
+		log.Error().Err(err).Msgf("Error reading requests.")
+		return failed(err)
 	}
 
-	// Initiate requests
-	reqs, err := req.GetRequests()
+	// Create results archive
+	outputFile := fabric.GetOutputFileName()
+	arc, cp, err := openArchive(fabric, cfg.Global.Resume, logger)
 	if err != nil {
-		log.Fatal().Err(err).Msgf("Error reading requests.")
+		log.Error().Err(err).Msgf("Error creating archive file: %s.", outputFile)
+		return failed(err)
 	}
 
 	// Allow overriding in-built queries with a single class query
@@ -74,9 +121,17 @@ func runSingleFabric(cfg *config.Config) {
 	}
 
 	// Batch and fetch queries in parallel
-	collectErr := collectFabric(client, arc, reqs, fabric)
+	result := collectFabric(ctx, stop, client, arc, cp, reqs, fabric, nil)
 
-	arc.Close()
+	if err := arc.Add(logName, capture.Stop()); err != nil {
+		log.Error().Err(err).Msg("Error adding log to archive.")
+	}
+	if err := arc.Close(); err != nil {
+		log.Error().Err(err).Msgf("Error closing archive file: %s.", outputFile)
+	}
+	result.archive = outputFile
+	result.duration = time.Since(start)
+	result.fingerprints = client.TrustedOnFirstUse()
 	log.Info().Msg("====== Complete ======")
 
 	path, err := os.Getwd()
@@ -85,67 +140,180 @@ func runSingleFabric(cfg *config.Config) {
 	}
 	outPath := filepath.Join(path, outputFile)
 
-	if collectErr != nil {
-		log.Warn().Err(collectErr).Msg("some data could not be fetched")
+	if result.Err() != nil {
+		log.Warn().Msg("some data could not be fetched")
 		log.Info().Msgf("Available data written to %s.", outPath)
 	} else {
 		log.Info().Msg("Collection complete.")
 		log.Info().Msgf("Please provide %s to Cisco Services for further analysis.", outPath)
 	}
-	if !fabric.GetConfirm() {
+	return finish(cfg, start, []fabricResult{result}, "", fabric.GetConfirm() || stop.Err() != nil)
+}
+
+// finish reports the outcome of a run and returns its exit code.
+// The summary table goes to stdout, or with --json to stderr, followed by
+// the JSON summary on stdout. aggregate is the multi-fabric archive, if any.
+// Unless confirm, --json or non-interactive mode is set, it waits for the user.
+func finish(cfg *config.Config, start time.Time, results []fabricResult, aggregate string, confirm bool) int {
+	recordFingerprints(cfg, results)
+	summary := os.Stdout
+	if cfg.Global.JSON {
+		summary = os.Stderr
+	}
+	fmt.Fprintln(summary)
+	printSummary(summary, results)
+	if cfg.Global.JSON {
+		if err := writeReport(os.Stdout, results, start, aggregate); err != nil {
+			log.Error().Err(err).Msg("Error writing JSON summary.")
+		}
+	} else if !confirm && !cfg.Global.NonInteractive {
 		pause("Press enter to exit.")
 	}
+	return exitCode(results)
+}
+
+// recordFingerprints writes the APIC certificates trusted on first use into the config file.
+// It runs once at the end of the run, so that fabrics collected in parallel
+// don't overwrite each other's fingerprints.
+func recordFingerprints(cfg *config.Config, results []fabricResult) {
+	files := map[string]map[string]string{}
+	for _, result := range results {
+		if len(result.fingerprints) == 0 {
+			continue
+		}
+		for _, fabric := range cfg.Fabrics {
+			if fabric.ConfigFile == "" || fabric.GetFabricName() != result.fabric {
+				continue
+			}
+			if files[fabric.ConfigFile] == nil {
+				files[fabric.ConfigFile] = map[string]string{}
+			}
+			files[fabric.ConfigFile][result.fabric] = strings.Join(result.fingerprints, ",")
+		}
+	}
+	for path, fingerprints := range files {
+		if err := config.RecordFingerprints(path, fingerprints); err != nil {
+			log.Error().Err(err).Msg("Cannot record APIC certificate fingerprints")
+			continue
+		}
+		log.Info().Msgf("Recorded APIC certificate fingerprints in %s", path)
+	}
 }
 
-func runMultiFabric(cfg *config.Config) {
+func runMultiFabric(ctx, stop context.Context, cfg *config.Config) int {
+	start := time.Now()
+	capture := log.StartCapture("")
 	log.Info().Msgf("Loaded config with %d fabric(s)", len(cfg.Fabrics))
 
-	// Collect each fabric in parallel
-	var g errgroup.Group
-	outputFiles := make([]string, 0, len(cfg.Fabrics))
+	// Collect fabrics in parallel, at most max_parallel_fabrics at a time, started
+	// fabric_start_delay apart. All fabrics share the request budget.
+	var names []string
 	for _, fabric := range cfg.Fabrics {
+		names = append(names, fabric.GetFabricName())
+	}
+	progress := newFabricProgress(names)
+	stopProgress := progress.watch(progressInterval)
+	defer stopProgress()
+	parallel := cfg.Global.MaxParallelFabrics
+	if parallel <= 0 {
+		parallel = len(cfg.Fabrics)
+	}
+	slots := make(chan struct{}, parallel)
+	budget := cli.NewBudget(cfg.Global.RequestBudget)
+
+	var wg sync.WaitGroup
+	manifest := newAggregateManifest()
+	outputFiles := make([]string, 0, len(cfg.Fabrics))
+	results := make([]fabricResult, len(cfg.Fabrics))
+	next := time.Now()
+	for i, fabric := range cfg.Fabrics {
 		fabric := fabric.MergeWithGlobal(cfg.Global)
+		select {
+		case <-stop.Done():
+		case <-time.After(time.Until(next)):
+			select {
+			case <-stop.Done():
+			case slots <- struct{}{}:
+			}
+		}
+		if stop.Err() != nil {
+			results[i] = fabricResult{
+				fabric: names[i],
+				err:    fmt.Errorf("%w: %w", cli.ErrNotStarted, context.Cause(stop)),
+			}
+			manifest.notStarted(fabric, results[i].err)
+			progress.skip(names[i])
+			continue
+		}
+		next = time.Now().Add(cfg.Global.FabricStartDelay)
 		outputFiles = append(outputFiles, fabric.GetOutputFileName())
-		g.Go(func() error {
-			return collectSingleFabric(fabric)
-		})
+		progress.start(names[i])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = collectSingleFabric(ctx, stop, fabric, cfg.Global.Resume, budget)
+			manifest.record(fabric, results[i].Err())
+			progress.finish(names[i], results[i])
+		}()
 	}
+	wg.Wait()
 
-	if err := g.Wait(); err != nil {
-		log.Error().Err(err).Msg("Error collecting one or more fabrics")
+	if code := exitCode(results); code != exitOK {
+		log.Error().Msgf("Collection of one or more fabrics is %s", statusText[code])
 	}
 
-	if err := createAggregateArchive(outputFiles); err != nil {
+	if err := createAggregateArchive(outputFiles, manifest, capture.Stop()); err != nil {
 		log.Error().Err(err).Msg("Failed to create aggregate archive")
 	}
 
 	log.Info().Msg("Multi-fabric collection complete.")
+	return finish(cfg, start, results, aggregateZip, true)
 }
 
-func collectSingleFabric(fabric config.FabricConfig) error {
+// collectSingleFabric collects a fabric of a multi-fabric run into its own archive,
+// sharing the request budget with the other fabrics.
+func collectSingleFabric(
+	ctx, stop context.Context,
+	fabric config.FabricConfig,
+	resume bool,
+	budget *cli.Budget,
+) (result fabricResult) {
+	start := time.Now()
+	defer func() { result.duration = time.Since(start) }()
 	fabricName := fabric.GetFabricName()
 	outputFile := fabric.GetOutputFileName()
 
+	capture := log.StartCapture(fabricName)
+	defer capture.Stop()
 	log := log.WithFabric(fabricName)
 	log.Info().Msgf("Starting collection for fabric: %s", fabricName)
 
 	// Initialize ACI HTTP client
-	client, err := cli.GetClient(fabric)
+	client, err := cli.GetClient(ctx, fabric)
 	if err != nil {
-		return fmt.Errorf("error initializing ACI client for %s: %w", fabricName, err)
+		log.Error().Err(err).Msg("Error initializing ACI client.")
+		return fabricResult{fabric: fabricName, err: fmt.Errorf("error initializing ACI client: %w", err)}
 	}
 
 	// Create results archive
-	arc, err := archive.NewWriter(outputFile)
+	arc, cp, err := openArchive(fabric, resume, log)
 	if err != nil {
-		return fmt.Errorf("error creating archive file %s: %w", outputFile, err)
+		return fabricResult{fabric: fabricName, err: fmt.Errorf("error creating archive file %s: %w", outputFile, err)}
 	}
-	defer arc.Close()
+	defer func() {
+		if err := arc.Add(logName, capture.Stop()); err != nil {
+			log.Error().Err(err).Msg("Error adding log to archive.")
+		}
+		arc.Close()
+		result.archive = outputFile
+		result.fingerprints = client.TrustedOnFirstUse()
+	}()
 
 	// Initiate requests
 	reqs, err := req.GetRequests()
 	if err != nil {
-		return fmt.Errorf("error reading requests for %s: %w", fabricName, err)
+		return fabricResult{fabric: fabricName, err: fmt.Errorf("error reading requests: %w", err)}
 	}
 
 	// Allow overriding in-built queries with a single class query
@@ -157,28 +325,43 @@ func collectSingleFabric(fabric config.FabricConfig) error {
 	}
 
 	// Batch and fetch queries in parallel
-	collectErr := collectFabric(client, arc, reqs, fabric)
+	result = collectFabric(ctx, stop, client, arc, cp, reqs, fabric, budget)
 
 	path, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("cannot read current working directory: %w", err)
+		result.err = fmt.Errorf("cannot read current working directory: %w", err)
+		return result
 	}
 	outPath := filepath.Join(path, outputFile)
 
-	if collectErr != nil {
-		log.Warn().Err(collectErr).Msgf("Some data could not be fetched for %s", fabricName)
+	if result.Err() != nil {
+		log.Warn().Msgf("Some data could not be fetched for %s", fabricName)
 	}
 
 	log.Info().Str("path", outPath).Msg("Collection complete.")
-	return collectErr
+	return result
 }
 
+// collectFabric counts the objects of all requests and fetches them into the archive
+// on a worker pool, priority classes and large classes first.
+// In adaptive mode the history of the number of requests in flight is added as concurrency.json.
+// Once stop is done no new requests are started; if that happens, a status.json
+// record of the collected, failed and pending requests is added to the archive.
+// A manifest.json describing the collection and the outcome of every request is always added.
+// Classes completed by a previous run are skipped, and each class collected is recorded
+// in the checkpoint, which is removed once the collection has completed without errors.
+// Requests hold a slot of the budget shared with the other fabrics of the run, if any.
+// The result lists every request that failed or was not started.
 func collectFabric(
+	ctx context.Context,
+	stop context.Context,
 	client aci.Client,
-	arc archive.Writer,
+	arc *recordingWriter,
+	cp *checkpoint,
 	reqs []req.Request,
 	cfg config.FabricConfig,
-) error {
+	budget *cli.Budget,
+) fabricResult {
 	var logger log.Logger
 	if cfg.GetFabricName() != "" {
 		logger = log.WithFabric(cfg.GetFabricName())
@@ -186,27 +369,108 @@ func collectFabric(
 		logger = log.New()
 	}
 
-	batch := 1
-	var firstErr error
-	for i := 0; i < len(reqs); i += cfg.GetBatchSize() {
-		var g errgroup.Group
-		logger.Info().Msgf("Fetching request batch %d", batch)
-		for j := i; j < i+cfg.GetBatchSize() && j < len(reqs); j++ {
-			req := reqs[j]
-			g.Go(func() error {
-				return cli.Fetch(client, req, arc, cfg)
-			})
+	manifest := newManifest(cfg)
+	if firmware, err := cli.GetFirmware(stop, client); err != nil {
+		logger.Warn().Err(err).Msg("Cannot read APIC firmware version")
+	} else {
+		manifest.Firmware = firmware
+		logger.Info().Str("firmware", firmware).Msg("APIC firmware version")
+	}
+
+	result := fabricResult{fabric: cfg.GetFabricName()}
+	status := newCollectionStatus()
+	var pending []req.Request
+	for _, req := range reqs {
+		if completed, ok := cp.done(req.Class); ok {
+			result.collected++
+			status.record(req.Class, nil)
+			manifest.resumed(req, completed)
+			continue
 		}
-		err := g.Wait()
-		if err != nil {
-			logger.Error().Err(err).Msg("Error fetching data.")
-			if firstErr == nil {
-				firstErr = err
+		pending = append(pending, req)
+	}
+	if skipped := len(reqs) - len(pending); skipped > 0 {
+		logger.Info().Msgf("Skipping %d classes completed by a previous run", skipped)
+	}
+	reqs = pending
+
+	// Count objects up front to page large classes right away.
+	// Preflight queries are abandoned on interrupt.
+	throttle := cli.NewThrottle(cfg, cli.SharedBudget(budget), cli.ThrottleLogger(logger))
+	reqs = cli.Preflight(stop, throttle, client, reqs, cfg)
+	reqs = cli.Prioritize(reqs, cfg.Priority)
+
+	var mu sync.Mutex
+	pool := cli.NewPool(stop, throttle)
+	for rank, req := range reqs {
+		cli.Schedule(ctx, pool, rank, client, req, arc, cfg, func(res cli.Result) {
+			manifest.record(req, res)
+			err := res.Err
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, cli.ErrNotStarted) {
+				status.skip(req.Class)
+				result.failures = append(result.failures, failure{class: req.Class, err: err})
+				return
+			}
+			status.record(req.Class, err)
+			if err != nil {
+				logger.Error().Err(err).Msg("Error fetching data.")
+				result.failures = append(result.failures, failure{class: req.Class, err: err})
+				return
 			}
+			result.collected++
+			if err := cp.complete(req.Class, arc.added(), res); err != nil {
+				logger.Error().Err(err).Msg("Error writing checkpoint.")
+			}
+		})
+	}
+	pool.Wait()
+
+	if cfg.GetAdaptiveConcurrency() {
+		if err := writeConcurrency(arc, throttle, logger); err != nil {
+			logger.Error().Err(err).Msg("Error writing concurrency history.")
+		}
+	}
+
+	var stopped error
+	if stop.Err() != nil {
+		stopped = context.Cause(stop)
+	}
+	if err := manifest.write(arc, stopped); err != nil {
+		logger.Error().Err(err).Msg("Error writing manifest.")
+	}
+	result.firmware = manifest.Firmware
+	result.requests = manifest.entries()
+
+	if stop.Err() != nil {
+		logger.Warn().Err(context.Cause(stop)).Msgf("Collection stopped with %d of %d requests started",
+			len(reqs)-result.notStarted(), len(reqs))
+		if err := status.write(arc, context.Cause(stop)); err != nil {
+			logger.Error().Err(err).Msg("Error writing collection status.")
 		}
-		batch++
 	}
-	return firstErr
+	if result.Err() == nil {
+		if err := cp.remove(); err != nil {
+			logger.Error().Err(err).Msg("Error removing checkpoint.")
+		}
+	}
+	return result
+}
+
+// writeConcurrency logs the range of requests in flight and adds its history to the archive.
+func writeConcurrency(arc archive.Writer, throttle *cli.Throttle, logger log.Logger) error {
+	history := throttle.History()
+	low, high := history[0].Limit, history[0].Limit
+	for _, sample := range history {
+		low, high = min(low, sample.Limit), max(high, sample.Limit)
+	}
+	logger.Info().Int("min", low).Int("max", high).Int("final", throttle.Limit()).Msg("Adaptive concurrency")
+	content, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	return arc.Add("concurrency.json", content)
 }
 
 func anyVerbose(cfg *config.Config) bool {
@@ -221,8 +485,9 @@ func anyVerbose(cfg *config.Config) bool {
 	return false
 }
 
-func createAggregateArchive(files []string) error {
-	const aggregateZip = "aci-collection.zip"
+// createAggregateArchive bundles the fabric archives with a manifest of the fabrics collected
+// and the combined log of all fabrics.
+func createAggregateArchive(files []string, manifest *aggregateManifest, combinedLog []byte) error {
 	arc, err := archive.NewWriter(aggregateZip)
 	if err != nil {
 		return err
@@ -251,5 +516,8 @@ func createAggregateArchive(files []string) error {
 			return fmt.Errorf("failed to add %s to aggregate archive: %w", file, err)
 		}
 	}
-	return nil
+	if err := arc.Add(logName, combinedLog); err != nil {
+		return fmt.Errorf("failed to add log to aggregate archive: %w", err)
+	}
+	return manifest.write(arc)
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"collector/pkg/archive"
+	"collector/pkg/cli"
+	"collector/pkg/config"
+	"collector/pkg/req"
+)
+
+// manifestVersion is the version of the manifest.json format.
+// It changes when fields are renamed or removed; new fields may be added at any time.
+const manifestVersion = 1
+
+// Request statuses recorded in the manifest.
+const (
+	statusCollected  = "collected"
+	statusFailed     = "failed"
+	statusNotStarted = "not_started"
+	// statusResumed marks a class carried over from the archive of an interrupted run.
+	statusResumed = "resumed"
+	// statusIncomplete marks a fabric archive that lacks some of the requested data.
+	statusIncomplete = "incomplete"
+)
+
+// manifest describes the contents of a fabric archive. It is written to the archive
+// as manifest.json, so that a missing class file can be told apart from a failed,
+// empty or never requested class.
+type manifest struct {
+	mu              sync.Mutex
+	ManifestVersion int               `json:"manifestVersion"`
+	Version         string            `json:"version"`
+	Fabric          string            `json:"fabric,omitempty"`
+	URL             string            `json:"url"`
+	Firmware        string            `json:"firmware,omitempty"`
+	Start           time.Time         `json:"start"`
+	End             time.Time         `json:"end"`
+	Stopped         string            `json:"stopped,omitempty"`
+	Settings        manifestSettings  `json:"settings"`
+	Requests        []manifestRequest `json:"requests"`
+}
+
+// manifestSettings are the effective settings of a collection, without secrets.
+type manifestSettings struct {
+	URLs                 []string          `json:"urls"`
+	Username             string            `json:"username,omitempty"`
+	CertName             string            `json:"certName,omitempty"`
+	Class                string            `json:"class"`
+	Query                map[string]string `json:"query,omitempty"`
+	DiscoverCluster      bool              `json:"discoverCluster"`
+	TLSVerify            bool              `json:"tlsVerify"`
+	BatchSize            int               `json:"batchSize"`
+	AdaptiveConcurrency  bool              `json:"adaptiveConcurrency"`
+	MaxConcurrency       int               `json:"maxConcurrency"`
+	MaxRequestsPerSecond float64           `json:"maxRequestsPerSecond"`
+	PageSize             int               `json:"pageSize"`
+	Priority             []string          `json:"priority,omitempty"`
+	RequestRetryCount    int               `json:"requestRetryCount"`
+	RetryDelay           int               `json:"retryDelay"`
+	MaxRetryDelay        int               `json:"maxRetryDelay"`
+}
+
+// manifestRequest is the outcome of a request.
+type manifestRequest struct {
+	Class      string            `json:"class"`
+	Query      map[string]string `json:"query,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Objects    int               `json:"objects"`
+	Pages      int               `json:"pages"`
+	Bytes      int64             `json:"bytes"`
+	DurationMs int64             `json:"durationMs"`
+}
+
+func newManifest(cfg config.FabricConfig) *manifest {
+	return &manifest{
+		ManifestVersion: manifestVersion,
+		Version:         version,
+		Fabric:          cfg.Name,
+		URL:             cfg.GetURLs()[0],
+		Start:           time.Now(),
+		Settings: manifestSettings{
+			URLs:                 cfg.GetURLs(),
+			Username:             cfg.Username,
+			CertName:             cfg.CertName,
+			Class:                cfg.GetClass(),
+			Query:                cfg.Query,
+			DiscoverCluster:      cfg.GetDiscoverCluster(),
+			TLSVerify:            cfg.GetTLSVerify(),
+			BatchSize:            cfg.GetBatchSize(),
+			AdaptiveConcurrency:  cfg.GetAdaptiveConcurrency(),
+			MaxConcurrency:       cfg.GetMaxConcurrency(),
+			MaxRequestsPerSecond: cfg.GetMaxRequestsPerSecond(),
+			PageSize:             cfg.GetPageSize(),
+			Priority:             cfg.Priority,
+			RequestRetryCount:    cfg.GetRequestRetryCount(),
+			RetryDelay:           cfg.GetRetryDelay(),
+			MaxRetryDelay:        cfg.GetMaxRetryDelay(),
+		},
+		Requests: []manifestRequest{},
+	}
+}
+
+// resumed records a class carried over from a previous run with the counts of that run.
+func (m *manifest) resumed(req req.Request, completed completedClass) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Requests = append(m.Requests, manifestRequest{
+		Class:      req.Class,
+		Query:      req.Query,
+		Status:     statusResumed,
+		Objects:    completed.Objects,
+		Pages:      completed.Pages,
+		Bytes:      completed.Bytes,
+		DurationMs: completed.DurationMs,
+	})
+}
+
+// record stores the outcome of a request.
+func (m *manifest) record(req req.Request, res cli.Result) {
+	entry := manifestRequest{
+		Class:      req.Class,
+		Query:      req.Query,
+		Status:     statusCollected,
+		Objects:    res.Objects,
+		Pages:      res.Pages,
+		Bytes:      res.Bytes,
+		DurationMs: res.Duration.Milliseconds(),
+	}
+	switch {
+	case errors.Is(res.Err, cli.ErrNotStarted):
+		entry.Status = statusNotStarted
+		entry.Error = res.Err.Error()
+	case res.Err != nil:
+		entry.Status = statusFailed
+		entry.Error = res.Err.Error()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Requests = append(m.Requests, entry)
+}
+
+// write adds the manifest to the archive, with requests sorted by class.
+// reason is why the collection stopped early, if it did.
+func (m *manifest) write(arc archive.Writer, reason error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.End = time.Now()
+	if reason != nil {
+		m.Stopped = reason.Error()
+	}
+	slices.SortStableFunc(m.Requests, func(a, b manifestRequest) int {
+		return strings.Compare(a.Class, b.Class)
+	})
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return arc.Add("manifest.json", content)
+}
+
+// entries returns the outcomes of the requests recorded.
+func (m *manifest) entries() []manifestRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.Requests)
+}
+
+// aggregateManifest describes the fabric archives bundled in the multi-fabric archive.
+type aggregateManifest struct {
+	mu              sync.Mutex
+	ManifestVersion int              `json:"manifestVersion"`
+	Version         string           `json:"version"`
+	Start           time.Time        `json:"start"`
+	End             time.Time        `json:"end"`
+	Fabrics         []manifestFabric `json:"fabrics"`
+}
+
+// manifestFabric is the outcome of the collection of a fabric.
+type manifestFabric struct {
+	Name    string   `json:"name"`
+	URLs    []string `json:"urls"`
+	Archive string   `json:"archive,omitempty"`
+	Status  string   `json:"status"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func newAggregateManifest() *aggregateManifest {
+	return &aggregateManifest{
+		ManifestVersion: manifestVersion,
+		Version:         version,
+		Start:           time.Now(),
+		Fabrics:         []manifestFabric{},
+	}
+}
+
+// record stores the outcome of the collection of a fabric.
+func (m *aggregateManifest) record(cfg config.FabricConfig, err error) {
+	entry := manifestFabric{
+		Name:    cfg.GetFabricName(),
+		URLs:    cfg.GetURLs(),
+		Archive: cfg.GetOutputFileName(),
+		Status:  statusCollected,
+	}
+	if err != nil {
+		entry.Status = statusIncomplete
+		entry.Error = err.Error()
+		if _, statErr := os.Stat(entry.Archive); statErr != nil {
+			entry.Status = statusFailed
+			entry.Archive = ""
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Fabrics = append(m.Fabrics, entry)
+}
+
+// notStarted records a fabric that was never started.
+func (m *aggregateManifest) notStarted(cfg config.FabricConfig, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Fabrics = append(m.Fabrics, manifestFabric{
+		Name:   cfg.GetFabricName(),
+		URLs:   cfg.GetURLs(),
+		Status: statusNotStarted,
+		Error:  err.Error(),
+	})
+}
+
+// write adds the manifest to the archive, with fabrics sorted by name.
+func (m *aggregateManifest) write(arc archive.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.End = time.Now()
+	slices.SortStableFunc(m.Fabrics, func(a, b manifestFabric) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return arc.Add("manifest.json", content)
+}
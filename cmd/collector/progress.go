@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"collector/pkg/log"
+)
+
+// progressInterval is how often the progress of a multi-fabric run is logged
+// while fabrics are queued or running.
+const progressInterval = 30 * time.Second
+
+// Fabric states in the progress of a multi-fabric run.
+const (
+	fabricQueued  = "queued"
+	fabricRunning = "running"
+	fabricDone    = "done"
+	fabricFailed  = "failed"
+)
+
+// fabricProgress tracks which fabrics of a multi-fabric run are queued, running,
+// done or failed, and logs it whenever a fabric changes state.
+type fabricProgress struct {
+	mu     sync.Mutex
+	names  []string
+	states map[string]string
+}
+
+func newFabricProgress(names []string) *fabricProgress {
+	p := &fabricProgress{names: names, states: make(map[string]string)}
+	for _, name := range names {
+		p.states[name] = fabricQueued
+	}
+	return p
+}
+
+// start marks a fabric as running.
+func (p *fabricProgress) start(name string) {
+	p.set(name, fabricRunning, "Fabric %s started")
+}
+
+// finish marks a fabric as done, or as failed unless it was collected completely.
+func (p *fabricProgress) finish(name string, result fabricResult) {
+	if result.status() == exitOK {
+		p.set(name, fabricDone, "Fabric %s done")
+		return
+	}
+	if status := result.status(); status != exitFailed {
+		p.set(name, fabricFailed, "Fabric %s failed ("+statusText[status]+")")
+		return
+	}
+	p.set(name, fabricFailed, "Fabric %s failed")
+}
+
+// skip marks a fabric that was never started as failed.
+func (p *fabricProgress) skip(name string) {
+	p.set(name, fabricFailed, "Fabric %s not started")
+}
+
+func (p *fabricProgress) set(name, state, format string) {
+	p.mu.Lock()
+	p.states[name] = state
+	summary := p.summary()
+	p.mu.Unlock()
+	log.Info().Msgf(format+"; %s", name, summary)
+}
+
+// summary counts the fabrics in each state, listing those running.
+func (p *fabricProgress) summary() string {
+	counts := make(map[string]int)
+	var running []string
+	for _, name := range p.names {
+		state := p.states[name]
+		counts[state]++
+		if state == fabricRunning {
+			running = append(running, name)
+		}
+	}
+	summary := fmt.Sprintf("%d queued, %d running", counts[fabricQueued], counts[fabricRunning])
+	if len(running) > 0 {
+		summary += " (" + strings.Join(running, ", ") + ")"
+	}
+	return summary + fmt.Sprintf(", %d done, %d failed", counts[fabricDone], counts[fabricFailed])
+}
+
+// pending reports whether any fabric is queued or running.
+func (p *fabricProgress) pending() bool {
+	for _, state := range p.states {
+		if state == fabricQueued || state == fabricRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// watch logs the progress every interval while fabrics are queued or running,
+// until the returned function is called.
+func (p *fabricProgress) watch(interval time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			p.mu.Lock()
+			pending, summary := p.pending(), p.summary()
+			p.mu.Unlock()
+			if pending {
+				log.Info().Msgf("Progress: %s", summary)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
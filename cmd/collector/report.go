@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// reportVersion is the version of the --json run summary format.
+// It changes when fields are renamed or removed; new fields may be added at any time.
+const reportVersion = 1
+
+// report is the --json summary of a run, printed to stdout.
+type report struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Version       string         `json:"version"`
+	Status        string         `json:"status"`
+	ExitCode      int            `json:"exitCode"`
+	Start         string         `json:"start"`
+	End           string         `json:"end"`
+	DurationMs    int64          `json:"durationMs"`
+	Archive       *reportArchive `json:"archive,omitempty"`
+	Fabrics       []reportFabric `json:"fabrics"`
+}
+
+// reportArchive is an archive written by the run.
+type reportArchive struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// reportFabric is the outcome of the collection of a fabric.
+type reportFabric struct {
+	Name       string            `json:"name"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Firmware   string            `json:"firmware,omitempty"`
+	DurationMs int64             `json:"durationMs"`
+	Archive    *reportArchive    `json:"archive,omitempty"`
+	Classes    reportClasses     `json:"classes"`
+	Requests   []manifestRequest `json:"requests"`
+}
+
+// reportClasses counts the requests of a fabric by status.
+type reportClasses struct {
+	Collected  int `json:"collected"`
+	Resumed    int `json:"resumed"`
+	Failed     int `json:"failed"`
+	NotStarted int `json:"notStarted"`
+}
+
+// writeReport writes the JSON summary of a run. aggregate is the multi-fabric
+// archive, if one was written.
+func writeReport(w io.Writer, results []fabricResult, start time.Time, aggregate string) error {
+	end := time.Now()
+	code := exitCode(results)
+	r := report{
+		SchemaVersion: reportVersion,
+		Version:       version,
+		Status:        statusText[code],
+		ExitCode:      code,
+		Start:         start.Format(time.RFC3339),
+		End:           end.Format(time.RFC3339),
+		DurationMs:    end.Sub(start).Milliseconds(),
+		Archive:       describeArchive(aggregate),
+		Fabrics:       []reportFabric{},
+	}
+	for _, result := range results {
+		f := reportFabric{
+			Name:       result.fabric,
+			Status:     statusText[result.status()],
+			Firmware:   result.firmware,
+			DurationMs: result.duration.Milliseconds(),
+			Archive:    describeArchive(result.archive),
+			Requests:   result.requests,
+		}
+		if result.err != nil {
+			f.Error = result.err.Error()
+		}
+		if f.Requests == nil {
+			f.Requests = []manifestRequest{}
+		}
+		for _, req := range f.Requests {
+			switch req.Status {
+			case statusCollected:
+				f.Classes.Collected++
+			case statusResumed:
+				f.Classes.Resumed++
+			case statusFailed:
+				f.Classes.Failed++
+			case statusNotStarted:
+				f.Classes.NotStarted++
+			}
+		}
+		r.Fabrics = append(r.Fabrics, f)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// describeArchive returns the absolute path, size and checksum of an archive,
+// nil if there is none.
+func describeArchive(path string) *reportArchive {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return nil
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return &reportArchive{Path: path, Bytes: n, SHA256: hex.EncodeToString(hash.Sum(nil))}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteReport(t *testing.T) {
+	a := assert.New(t)
+
+	archive := filepath.Join(t.TempDir(), "emea.zip")
+	a.NoError(os.WriteFile(archive, []byte("zip"), 0o600))
+	results := []fabricResult{
+		{
+			fabric:    "emea",
+			collected: 2,
+			failures:  []failure{{"fvBD", errors.New("request failed")}},
+			archive:   archive,
+			firmware:  "5.2(7f)",
+			duration:  2 * time.Second,
+			requests: []manifestRequest{
+				{Class: "fvTenant", Status: statusCollected, Objects: 3, Pages: 0, Bytes: 120, DurationMs: 15},
+				{Class: "fvAp", Status: statusResumed, Objects: 1, Bytes: 40},
+				{Class: "fvBD", Status: statusFailed, Error: "request failed"},
+				{Class: "fvCtx", Status: statusNotStarted, Error: "fvCtx not started: interrupted"},
+			},
+		},
+		{fabric: "us", err: errors.New("connection refused")},
+	}
+
+	var buf bytes.Buffer
+	a.NoError(writeReport(&buf, results, time.Now().Add(-time.Minute), ""))
+	var report map[string]any
+	a.NoError(json.Unmarshal(buf.Bytes(), &report))
+
+	// Field names are the schema; renaming or removing one needs a new schema version
+	keys := func(m any) []string {
+		var names []string
+		for name := range m.(map[string]any) {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return names
+	}
+	a.Equal([]string{"durationMs", "end", "exitCode", "fabrics", "schemaVersion", "start", "status", "version"}, keys(report))
+	a.Equal(float64(1), report["schemaVersion"])
+	a.Equal(float64(exitPartial), report["exitCode"])
+	a.Equal("partial", report["status"])
+
+	fabrics := report["fabrics"].([]any)
+	a.Len(fabrics, 2)
+	emea, us := fabrics[0].(map[string]any), fabrics[1].(map[string]any)
+	a.Equal([]string{"archive", "classes", "durationMs", "firmware", "name", "requests", "status"}, keys(emea))
+	a.Equal("partial", emea["status"])
+	a.Equal(float64(2000), emea["durationMs"])
+	a.Equal(map[string]any{"collected": float64(1), "resumed": float64(1), "failed": float64(1), "notStarted": float64(1)},
+		emea["classes"])
+	a.Equal([]string{"bytes", "path", "sha256"}, keys(emea["archive"]))
+	a.Equal(float64(3), emea["archive"].(map[string]any)["bytes"])
+
+	requests := emea["requests"].([]any)
+	a.Len(requests, 4)
+	a.Equal([]string{"bytes", "class", "durationMs", "objects", "pages", "status"}, keys(requests[0]))
+	a.Equal([]string{"bytes", "class", "durationMs", "error", "objects", "pages", "status"}, keys(requests[2]))
+
+	// Fabrics that failed outright report their error and no requests
+	a.Equal([]string{"classes", "durationMs", "error", "name", "requests", "status"}, keys(us))
+	a.Equal("connection refused", us["error"])
+	a.Equal([]any{}, us["requests"])
+}
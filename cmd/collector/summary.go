@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"collector/pkg/aci"
+	"collector/pkg/cli"
+)
+
+// Exit codes of the collector.
+const (
+	exitOK = 0
+	// exitFailed means no data was collected.
+	exitFailed = 1
+	// exitPartial means some of the requested data is missing from the archives.
+	exitPartial = 2
+	// exitAuth means no data was collected because authentication to the APIC failed.
+	exitAuth = 3
+	// exitAborted means the run was aborted with a second interrupt, as by a shell.
+	exitAborted = 130
+)
+
+// failure is a request that failed or was never started.
+type failure struct {
+	class string
+	err   error
+}
+
+// fabricResult is the outcome of the collection of a fabric.
+type fabricResult struct {
+	fabric string
+	// collected is the number of classes in the archive, including resumed classes.
+	collected int
+	failures  []failure
+	// err is why the fabric could not be collected at all, e.g. failed authentication.
+	err error
+
+	// archive is the output file, if it was written.
+	archive  string
+	firmware string
+	duration time.Duration
+	requests []manifestRequest
+	// fingerprints are the APIC certificates trusted on first use, if any.
+	fingerprints []string
+}
+
+// Err returns all errors of the collection, nil if it is complete.
+func (r fabricResult) Err() error {
+	errs := []error{r.err}
+	for _, f := range r.failures {
+		errs = append(errs, f.err)
+	}
+	return errors.Join(errs...)
+}
+
+// notStarted returns the number of requests that were never started.
+func (r fabricResult) notStarted() int {
+	n := 0
+	for _, f := range r.failures {
+		if errors.Is(f.err, cli.ErrNotStarted) {
+			n++
+		}
+	}
+	return n
+}
+
+// status returns the exit code the result calls for.
+func (r fabricResult) status() int {
+	switch {
+	case r.Err() == nil:
+		return exitOK
+	case r.collected > 0:
+		return exitPartial
+	case errors.Is(r.Err(), aci.ErrUnauthorized):
+		return exitAuth
+	}
+	return exitFailed
+}
+
+var statusText = map[int]string{
+	exitOK:      "complete",
+	exitFailed:  "failed",
+	exitPartial: "partial",
+	exitAuth:    "authentication failed",
+}
+
+// exitCode returns the exit code of a run: success if all fabrics are complete,
+// partial if any data was collected, otherwise authentication failure if all
+// fabrics failed to authenticate, or failure.
+func exitCode(results []fabricResult) int {
+	complete, collected, auth := true, false, true
+	for _, r := range results {
+		status := r.status()
+		complete = complete && status == exitOK
+		collected = collected || status == exitOK || status == exitPartial
+		auth = auth && status == exitAuth
+	}
+	switch {
+	case complete:
+		return exitOK
+	case collected:
+		return exitPartial
+	case auth:
+		return exitAuth
+	}
+	return exitFailed
+}
+
+// printSummary writes a table of the outcome of each fabric and of every failure.
+// Requests that were never started are listed once per fabric.
+func printSummary(w io.Writer, results []fabricResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FABRIC\tSTATUS\tCOLLECTED\tFAILED\tNOT STARTED")
+	for _, r := range results {
+		notStarted := r.notStarted()
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", r.fabric, statusText[r.status()],
+			r.collected, len(r.failures)-notStarted, notStarted)
+	}
+	tw.Flush()
+
+	var failed []string
+	for _, r := range results {
+		if r.err != nil {
+			failed = append(failed, fmt.Sprintf("%s\t-\t%v", r.fabric, r.err))
+		}
+		var skipped *failure
+		for _, f := range r.failures {
+			if errors.Is(f.err, cli.ErrNotStarted) {
+				skipped = &f
+				continue
+			}
+			failed = append(failed, fmt.Sprintf("%s\t%s\t%v", r.fabric, f.class, f.err))
+		}
+		if skipped != nil {
+			failed = append(failed, fmt.Sprintf("%s\t%d classes\t%s", r.fabric, r.notStarted(),
+				strings.TrimPrefix(skipped.err.Error(), skipped.class+" ")))
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(tw, "FABRIC\tCLASS\tERROR")
+	for _, line := range failed {
+		fmt.Fprintln(tw, line)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"collector/pkg/aci"
+	"collector/pkg/cli"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Results of a fabric as collectFabric and collectSingleFabric return them.
+var (
+	complete     = fabricResult{fabric: "complete", collected: 3}
+	partial      = fabricResult{fabric: "partial", collected: 2, failures: []failure{{"fvBD", errors.New("request failed")}}}
+	unauthorized = fabricResult{fabric: "unauthorized",
+		err: fmt.Errorf("cannot authenticate to the APIC: %w", &aci.APIError{StatusCode: 401})}
+	failed      = fabricResult{fabric: "failed", err: errors.New("connection refused")}
+	interrupted = fabricResult{fabric: "interrupted", collected: 1, failures: []failure{
+		{"fvBD", fmt.Errorf("fvBD %w: %w", cli.ErrNotStarted, errInterrupted)},
+	}}
+	notStarted = fabricResult{fabric: "not started", failures: []failure{
+		{"fvTenant", fmt.Errorf("fvTenant %w: %w", cli.ErrNotStarted, errInterrupted)},
+		{"fvBD", fmt.Errorf("fvBD %w: %w", cli.ErrNotStarted, errInterrupted)},
+	}}
+)
+
+func TestExitCodes(t *testing.T) {
+	a := assert.New(t)
+
+	// The exit codes are part of the interface for scripts
+	a.Equal(0, exitOK)
+	a.Equal(1, exitFailed)
+	a.Equal(2, exitPartial)
+	a.Equal(3, exitAuth)
+	a.Equal(130, exitAborted)
+}
+
+func TestFabricStatus(t *testing.T) {
+	for _, tt := range []struct {
+		result fabricResult
+		status int
+		text   string
+	}{
+		{complete, exitOK, "complete"},
+		{partial, exitPartial, "partial"},
+		{unauthorized, exitAuth, "authentication failed"},
+		{failed, exitFailed, "failed"},
+		{interrupted, exitPartial, "partial"},
+		{notStarted, exitFailed, "failed"},
+	} {
+		t.Run(tt.result.fabric, func(t *testing.T) {
+			assert.Equal(t, tt.status, tt.result.status())
+			assert.Equal(t, tt.text, statusText[tt.result.status()])
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		results []fabricResult
+		code    int
+	}{
+		{"all collected", []fabricResult{complete}, exitOK},
+		{"partial", []fabricResult{partial}, exitPartial},
+		{"authentication failed", []fabricResult{unauthorized}, exitAuth},
+		{"failed", []fabricResult{failed}, exitFailed},
+		{"interrupted", []fabricResult{interrupted}, exitPartial},
+		{"never started", []fabricResult{notStarted}, exitFailed},
+		{"all fabrics collected", []fabricResult{complete, complete}, exitOK},
+		{"one fabric partial", []fabricResult{complete, partial}, exitPartial},
+		{"one fabric failed", []fabricResult{complete, failed}, exitPartial},
+		{"one fabric unauthorized", []fabricResult{unauthorized, complete}, exitPartial},
+		{"all fabrics unauthorized", []fabricResult{unauthorized, unauthorized}, exitAuth},
+		{"unauthorized and failed", []fabricResult{unauthorized, failed}, exitFailed},
+		{"failed and never started", []fabricResult{failed, notStarted}, exitFailed},
+		{"interrupted and never started", []fabricResult{interrupted, notStarted}, exitPartial},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, exitCode(tt.results))
+		})
+	}
+}
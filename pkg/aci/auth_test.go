@@ -0,0 +1,71 @@
+package aci
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestParsePrivateKey tests the ParsePrivateKey function.
+func TestParsePrivateKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	// PKCS #1
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	parsed, err := ParsePrivateKey(pkcs1)
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	// PKCS #8
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	parsed, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	// Not PEM
+	_, err = ParsePrivateKey([]byte("garbage"))
+	assert.Error(t, err)
+}
+
+// TestCertAuth tests signature-based authentication.
+func TestCertAuth(t *testing.T) {
+	defer gock.Off()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	client, _ := NewClient(testHost, "usr", "", CertAuth("mycert", key))
+	gock.InterceptClient(client.HTTPClient)
+
+	// Login and Refresh never reach the APIC
+	assert.NoError(t, client.Login(ctx))
+	assert.NoError(t, client.Refresh(ctx))
+
+	var cookies map[string]string
+	gock.New(testURL).
+		Post("/url.json").
+		SetMatcher(gock.NewBasicMatcher()).
+		AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+			cookies = map[string]string{}
+			for _, c := range req.Cookies() {
+				cookies[c.Name] = c.Value
+			}
+			return true, nil
+		}).
+		Reply(200)
+	_, err := client.Post(ctx, "/url", "{}")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "uni/userext/user-usr/usercert-mycert", cookies["APIC-Certificate-DN"])
+	assert.Equal(t, "v1.0", cookies["APIC-Certificate-Algorithm"])
+	sig, err := base64.StdEncoding.DecodeString(cookies["APIC-Request-Signature"])
+	assert.NoError(t, err)
+	digest := sha256.Sum256([]byte("POST/url.json{}"))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+}
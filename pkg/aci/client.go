@@ -1,13 +1,14 @@
 package aci
 
 import (
+	"context"
+	"crypto/rsa"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
-	"strconv"
 	"strings"
 	"time"
 
@@ -22,16 +23,23 @@ import (
 type Client struct {
 	// HTTPClient is the *http.Client used for API requests.
 	HTTPClient *http.Client
-	// host is the APIC IP or hostname, e.g. 10.0.0.1:80 (port is optional).
-	host string
+	// cluster holds the APIC IPs or hostnames, e.g. 10.0.0.1:8443 (port is optional,
+	// default 443), and tracks which one is active.
+	cluster *cluster
 	// Usr is the APIC username.
 	Usr string
 	// Pwd is the APIC password.
 	Pwd string
-	// LastRefresh is the timestamp of the last token refresh interval.
-	LastRefresh time.Time
-	// Token is the current authentication token
-	Token string
+	// session holds the authentication token shared by all copies of the client.
+	session *session
+	// certName is the name of the user certificate used for signature-based authentication.
+	certName string
+	// key is the private key used for signature-based authentication.
+	key *rsa.PrivateKey
+	// tls holds the certificate fingerprints pinned for connections to the APIC.
+	tls *tlsState
+	// logger logs token renewals and failovers.
+	logger log.Logger
 }
 
 // NewClient creates a new ACI HTTP client.
@@ -39,11 +47,6 @@ type Client struct {
 //
 //	client, _ := NewClient("apic", "user", "password", RequestTimeout(120))
 func NewClient(url, usr, pwd string, mods ...func(*Client)) (Client, error) {
-	// Normalize the URL
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		url = "https://" + url
-	}
-
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
@@ -57,9 +60,12 @@ func NewClient(url, usr, pwd string, mods ...func(*Client)) (Client, error) {
 
 	client := Client{
 		HTTPClient: &httpClient,
-		host:       url,
+		cluster:    &cluster{hosts: []string{normalizeHost(url)}},
 		Usr:        usr,
 		Pwd:        pwd,
+		session:    &session{interval: defaultRefreshInterval},
+		tls:        &tlsState{},
+		logger:     log.New(),
 	}
 	for _, mod := range mods {
 		mod(&client)
@@ -68,8 +74,8 @@ func NewClient(url, usr, pwd string, mods ...func(*Client)) (Client, error) {
 }
 
 // NewReq creates a new Req request for this client.
-func (client Client) NewReq(method, uri string, body io.Reader, mods ...func(*Req)) Req {
-	httpReq, err := http.NewRequest(method, client.host+":443"+uri+".json", body)
+func (client Client) NewReq(ctx context.Context, method, uri string, body io.Reader, mods ...func(*Req)) Req {
+	httpReq, err := http.NewRequestWithContext(ctx, method, withPort(client.Host())+uri+".json", body)
 	if err != nil {
 		panic(err)
 	}
@@ -90,43 +96,102 @@ func RequestTimeout(x time.Duration) func(*Client) {
 	}
 }
 
+// Logger sets the logger of the client, e.g. one with the fabric as context.
+func Logger(logger log.Logger) func(*Client) {
+	return func(client *Client) {
+		client.logger = logger
+	}
+}
+
 // Do makes a request.
 // Requests for Do are built ouside of the client, e.g.
 //
-//	req := client.NewReq("GET", "/api/class/fvBD", nil)
+//	req := client.NewReq(ctx, "GET", "/api/class/fvBD", nil)
 //	res := client.Do(req)
+//
+// The token is refreshed when due and the request is retried after logging in
+// again if the APIC rejects the token.
+// On connection errors or 5xx responses other than throttling the request is retried
+// on the next APIC of the cluster, logging in again first. Throttled requests are
+// left to the caller to retry, as every APIC of the cluster shares the load.
 func (client *Client) Do(req Req) (Res, error) {
-	if req.Refresh && time.Since(client.LastRefresh) > 480*time.Second {
-		if err := client.Refresh(); err != nil {
+	if req.Refresh && !client.UsesCertAuth() {
+		if err := client.refreshIfDue(req.HTTPReq.Context()); err != nil {
 			return Res{}, err
 		}
 	}
 
+	relogged := false
+	for attempt := 1; ; {
+		host := client.Host()
+		generation := client.session.gen()
+		res, failover, err := client.do(req, host)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.tokenInvalid() && req.Refresh && !relogged {
+			relogged = true
+			client.logger.Warn().Str("host", host).Msg("token was invalid, logging in again")
+			if err := client.relogin(req.HTTPReq.Context(), generation); err != nil {
+				return Res{}, err
+			}
+			continue
+		}
+		if !failover || attempt >= client.cluster.size() {
+			if err == nil && req.servedBy != nil {
+				*req.servedBy = host
+			}
+			return res, err
+		}
+		attempt++
+		next := client.cluster.failover(host)
+		client.logger.Warn().Err(err).Str("from", host).Str("to", next).Msg("APIC failed, failing over")
+		if req.Refresh {
+			if err := client.relogin(req.HTTPReq.Context(), generation); err != nil {
+				return Res{}, err
+			}
+		}
+	}
+}
+
+// do makes a single attempt of a request against the given APIC.
+// The returned bool reports whether the failure warrants failing over to another APIC.
+func (client *Client) do(req Req, host string) (Res, bool, error) {
+	if err := setHost(req.HTTPReq, host); err != nil {
+		return Res{}, false, err
+	}
+	if req.HTTPReq.GetBody != nil {
+		body, err := req.HTTPReq.GetBody()
+		if err != nil {
+			return Res{}, false, err
+		}
+		req.HTTPReq.Body = body
+	}
+	if client.UsesCertAuth() {
+		if err := client.sign(req.HTTPReq); err != nil {
+			return Res{}, false, err
+		}
+	}
+
 	httpRes, err := client.HTTPClient.Do(req.HTTPReq)
 	if err != nil {
-		return Res{}, err
+		// A canceled request is not the APIC's fault
+		return Res{}, req.HTTPReq.Context().Err() == nil, err
 	}
 	defer httpRes.Body.Close()
 
 	body, err := io.ReadAll(httpRes.Body)
 	if err != nil {
-		return Res{}, errors.New("cannot decode response body")
+		return Res{}, false, errors.New("cannot decode response body")
 	}
 
 	res := Res(gjson.ParseBytes(body))
 
-	if httpRes.StatusCode == 400 {
-		errStr := res.Get("imdata.0.error.attributes.text").Str
-		if strings.Contains(errStr, "Unable to process the query, result dataset is too big") {
-			return Res{}, errors.New("result dataset is too big")
-		}
-	}
-
 	if httpRes.StatusCode != http.StatusOK {
-		return Res{}, fmt.Errorf("received HTTP status %d", httpRes.StatusCode)
+		apiErr := newAPIError(httpRes.StatusCode, res)
+		apiErr.RetryAfter = parseRetryAfter(httpRes.Header.Get("Retry-After"), time.Now())
+		return Res{}, httpRes.StatusCode >= 500 && !errors.Is(apiErr, ErrThrottled), apiErr
 	}
 
-	return res, nil
+	return res, false, nil
 }
 
 // Get makes a GET request and returns a GJSON result.
@@ -145,74 +210,9 @@ func (client *Client) Do(req Req) (Res, error) {
 //	  ],
 //	  "totalCount": "1"
 //	}
-func (client *Client) Get(path string, mods ...func(*Req)) (Res, error) {
-	// log := log
-	req := client.NewReq("GET", path, nil, mods...)
-	res, err := client.Do(req)
-	// FIXME this is currently hanging. Disabling until it can be fixed
-	// if err != nil && err.Error() == "result dataset is too big" && len(mods) == 0 {
-	// 	log.Info().Msgf("falling back to pagination for %s", path)
-	// 	res, err = client.GetWithPagination(path, mods...)
-	// }
-	return res, err
-}
-
-// GetWithPagination performs a get request with pagination
-func (client *Client) GetWithPagination(path string, mods ...func(*Req)) (Res, error) {
-	pageSize := 10
-	pageNumber := 0
-	mods = append(mods, Query("page", strconv.Itoa(pageNumber)))
-	mods = append(mods, Query("page-size", strconv.Itoa(pageSize)))
-	req := client.NewReq("GET", path, nil, mods...)
-	res, err := client.Do(req)
-	if err != nil {
-		return res, err
-	}
-	if !res.Get("imdata").IsArray() {
-		return res, errors.New("imdata is not an array")
-	}
-
-	totalCount := res.Get("totalCount").Str
-	log.Debug().
-		Str("path", path).
-		Str("totalCount", totalCount).
-		Msg("starting pagination")
-	count, err := strconv.Atoi(res.Get("totalCount").Str)
-	if err != nil {
-		return res, err
-	}
-
-	var tmp string
-	for i, value := range res.Get("imdata").Array() {
-		if i == 0 {
-			tmp = value.Raw
-		} else {
-			tmp = tmp + "," + value.Raw
-		}
-	}
-
-	count = count - pageSize
-	for count > 0 {
-		pageNumber = pageNumber + 1
-		log.Debug().Str("path", path).Msgf("page %d remaining %d", pageNumber, count)
-		mods = append(mods, Query("page", strconv.Itoa(pageNumber)))
-		mods = append(mods, Query("page-size", strconv.Itoa(pageSize)))
-		req := client.NewReq("GET", path, nil, mods...)
-		res, err := client.Do(req)
-		if err != nil {
-			return res, err
-		}
-		if !res.Get("imdata").IsArray() {
-			return res, errors.New("imdata is not an array")
-		}
-		for _, value := range res.Get("imdata").Array() {
-			tmp = tmp + "," + value.Raw
-		}
-		count = count - pageSize
-	}
-
-	json := fmt.Sprintf(`{"totalCount":%s,"imdata":[%s]}`, totalCount, tmp)
-	return gjson.Parse(json), err
+func (client *Client) Get(ctx context.Context, path string, mods ...func(*Req)) (Res, error) {
+	req := client.NewReq(ctx, "GET", path, nil, mods...)
+	return client.Do(req)
 }
 
 // GetClass makes a GET request by class and unwraps the results.
@@ -228,8 +228,8 @@ func (client *Client) GetWithPagination(path string, mods ...func(*Req)) (Res, e
 //	    }
 //	  }
 //	]
-func (client *Client) GetClass(class string, mods ...func(*Req)) (Res, error) {
-	res, err := client.Get(fmt.Sprintf("/api/class/%s", class), mods...)
+func (client *Client) GetClass(ctx context.Context, class string, mods ...func(*Req)) (Res, error) {
+	res, err := client.Get(ctx, fmt.Sprintf("/api/class/%s", class), mods...)
 	if err != nil {
 		return res, err
 	}
@@ -247,8 +247,8 @@ func (client *Client) GetClass(class string, mods ...func(*Req)) (Res, error) {
 //	    }
 //	  }
 //	}
-func (client *Client) GetDn(dn string, mods ...func(*Req)) (Res, error) {
-	res, err := client.Get(fmt.Sprintf("/api/mo/%s", dn), mods...)
+func (client *Client) GetDn(ctx context.Context, dn string, mods ...func(*Req)) (Res, error) {
+	res, err := client.Get(ctx, fmt.Sprintf("/api/mo/%s", dn), mods...)
 	if err != nil {
 		return res, err
 	}
@@ -257,40 +257,50 @@ func (client *Client) GetDn(dn string, mods ...func(*Req)) (Res, error) {
 
 // Post makes a POST request and returns a GJSON result.
 // Hint: Use the Body struct to easily create POST body data.
-func (client *Client) Post(path, data string, mods ...func(*Req)) (Res, error) {
-	req := client.NewReq("POST", path, strings.NewReader(data), mods...)
+func (client *Client) Post(ctx context.Context, path, data string, mods ...func(*Req)) (Res, error) {
+	req := client.NewReq(ctx, "POST", path, strings.NewReader(data), mods...)
 	return client.Do(req)
 }
 
 // Login authenticates to the APIC.
-func (client *Client) Login() error {
+// Login is a no-op when signature-based authentication is used.
+func (client *Client) Login(ctx context.Context) error {
+	if client.UsesCertAuth() {
+		return nil
+	}
 	data := fmt.Sprintf(`{"aaaUser":{"attributes":{"name":"%s","pwd":"%s"}}}`,
 		client.Usr,
 		client.Pwd,
 	)
-	res, err := client.Post("/api/aaaLogin", data, NoRefresh)
+	res, err := client.Post(ctx, "/api/aaaLogin", data, NoRefresh)
 	if err != nil {
 		return err
 	}
 	errText := res.Get("imdata.0.error.attributes.text").Str
 	if errText != "" {
-		return fmt.Errorf("authentication error: %s", errText)
+		return fmt.Errorf("authentication error: %w: %s", ErrUnauthorized, errText)
 	}
-	client.Token = res.Get("imdata.0.aaaLogin.attributes.token").Str
-	client.LastRefresh = time.Now()
+	attrs := res.Get("imdata.0.aaaLogin.attributes")
+	client.session.update(attrs.Get("token").Str, attrs.Get("refreshTimeoutSeconds").Str)
 	return nil
 }
 
 // Refresh refreshes the authentication token.
 // Note that this will be handled automatically be default.
-// Refresh will be checked every request and the token will be refreshed after 8 minutes.
+// Refresh will be checked every request and the token will be refreshed after 80% of the
+// refreshTimeoutSeconds reported by the APIC, i.e. 8 minutes for the default 10 minute timeout.
+// Concurrent requests share a single refresh.
 // Pass aci.NoRefresh to prevent automatic refresh handling and handle it directly instead.
-func (client *Client) Refresh() error {
-	res, err := client.Get("/api/aaaRefresh", NoRefresh)
+// Refresh is a no-op when signature-based authentication is used.
+func (client *Client) Refresh(ctx context.Context) error {
+	if client.UsesCertAuth() {
+		return nil
+	}
+	res, err := client.Get(ctx, "/api/aaaRefresh", NoRefresh)
 	if err != nil {
 		return err
 	}
-	client.Token = res.Get("imdata.0.aaaRefresh.attributes.token").Str
-	client.LastRefresh = time.Now()
+	attrs := res.Get("imdata.0.aaaRefresh.attributes")
+	client.session.update(attrs.Get("token").Str, attrs.Get("refreshTimeoutSeconds").Str)
 	return nil
 }
@@ -1,6 +1,7 @@
 package aci
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -17,9 +18,11 @@ const (
 	testURL  = "https://" + testHost
 )
 
+// ctx is the context used for test requests.
+var ctx = context.Background()
+
 func testClient() Client {
 	client, _ := NewClient(testHost, "usr", "pwd")
-	client.LastRefresh = time.Now()
 	gock.InterceptClient(client.HTTPClient)
 	return client
 }
@@ -45,18 +48,18 @@ func TestClientLogin(t *testing.T) {
 
 	// Successful login
 	gock.New(testURL).Post("/api/aaaLogin.json").Reply(200)
-	assert.NoError(t, client.Login())
+	assert.NoError(t, client.Login(ctx))
 
 	// Invalid HTTP status code
 	gock.New(testURL).Post("/api/aaaLogin.json").Reply(405)
-	assert.Error(t, client.Login())
+	assert.Error(t, client.Login(ctx))
 
 	// JSON error from Client
 	gock.New(testURL).
 		Post("/api/aaaLogin.json").
 		Reply(200).
 		BodyString(Body{}.Set("imdata.0.error.attributes.text", "error").Str)
-	assert.Error(t, client.Login())
+	assert.Error(t, client.Login(ctx))
 }
 
 // TestClientRefresh tests the Client::Refresh method.
@@ -65,7 +68,7 @@ func TestClientRefresh(t *testing.T) {
 	client := testClient()
 
 	gock.New(testURL).Get("/api/aaaRefresh.json").Reply(200)
-	assert.NoError(t, client.Refresh())
+	assert.NoError(t, client.Refresh(ctx))
 }
 
 // TestClientGet tests the Client::Get method.
@@ -76,17 +79,17 @@ func TestClientGet(t *testing.T) {
 
 	// Success
 	gock.New(testURL).Get("/url.json").Reply(200)
-	_, err = client.Get("/url")
+	_, err = client.Get(ctx, "/url")
 	assert.NoError(t, err)
 
 	// HTTP error
 	gock.New(testURL).Get("/url.json").ReplyError(errors.New("fail"))
-	_, err = client.Get("/url")
+	_, err = client.Get(ctx, "/url")
 	assert.Error(t, err)
 
 	// Invalid HTTP status code
 	gock.New(testURL).Get("/url.json").Reply(405)
-	_, err = client.Get("/url")
+	_, err = client.Get(ctx, "/url")
 	assert.Error(t, err)
 
 	// Error decoding response body
@@ -97,15 +100,15 @@ func TestClientGet(t *testing.T) {
 			res.Body = io.NopCloser(ErrReader{})
 			return res
 		})
-	_, err = client.Get("/url")
+	_, err = client.Get(ctx, "/url")
 	assert.Error(t, err)
 
 	// Force token refresh and throw an error
-	client.LastRefresh = time.Now().AddDate(0, 0, -1)
+	client.session.lastRefresh = time.Now().AddDate(0, 0, -1)
 	gock.New(testURL).
 		Get("/api/aaaRefresh.json").
 		ReplyError(errors.New("fail"))
-	_, err = client.Get("/url")
+	_, err = client.Get(ctx, "/url")
 	assert.Error(t, err)
 }
 
@@ -122,7 +125,7 @@ func TestClientGetClass(t *testing.T) {
 			Set("imdata.0.fvTenant.attributes.name", "zero").
 			Set("imdata.1.fvTenant.attributes.name", "one").
 			Str)
-	res, _ := client.GetClass("fvTenant")
+	res, _ := client.GetClass(ctx, "fvTenant")
 	if !assert.Len(t, res.Array(), 2) {
 		fmt.Println(res.Get("@pretty"))
 	}
@@ -132,7 +135,7 @@ func TestClientGetClass(t *testing.T) {
 
 	// HTTP error
 	gock.New(testURL).Get("/api/class/test.json").ReplyError(errors.New("fail"))
-	_, err := client.GetClass("test")
+	_, err := client.GetClass(ctx, "test")
 	assert.Error(t, err)
 }
 
@@ -146,7 +149,7 @@ func TestClientGetDn(t *testing.T) {
 		Get("/api/mo/uni/tn-test.json").
 		Reply(200).
 		BodyString(Body{}.Set("imdata.0.fvTenant.attributes.name", "test").Str)
-	res, _ := client.GetDn("uni/tn-test")
+	res, _ := client.GetDn(ctx, "uni/tn-test")
 	if !assert.Equal(t, "test", res.Get("fvTenant.attributes.name").Str) {
 		fmt.Println(res.Get("@pretty"))
 	}
@@ -155,7 +158,7 @@ func TestClientGetDn(t *testing.T) {
 	gock.New(testURL).
 		Get("/api/mo/uni/fail.json").
 		ReplyError(errors.New("fail"))
-	_, err := client.GetDn("uni/fail")
+	_, err := client.GetDn(ctx, "uni/fail")
 	assert.Error(t, err)
 }
 
@@ -168,17 +171,17 @@ func TestClientPost(t *testing.T) {
 
 	// Success
 	gock.New(testURL).Post("/url.json").Reply(200)
-	_, err = client.Post("/url", "{}")
+	_, err = client.Post(ctx, "/url", "{}")
 	assert.NoError(t, err)
 
 	// HTTP error
 	gock.New(testURL).Post("/url.json").ReplyError(errors.New("fail"))
-	_, err = client.Post("/url", "{}")
+	_, err = client.Post(ctx, "/url", "{}")
 	assert.Error(t, err)
 
 	// Invalid HTTP status code
 	gock.New(testURL).Post("/url.json").Reply(405)
-	_, err = client.Post("/url", "{}")
+	_, err = client.Post(ctx, "/url", "{}")
 	assert.Error(t, err)
 
 	// Error decoding response body
@@ -189,12 +192,12 @@ func TestClientPost(t *testing.T) {
 			res.Body = io.NopCloser(ErrReader{})
 			return res
 		})
-	_, err = client.Post("/url", "{}")
+	_, err = client.Post(ctx, "/url", "{}")
 	assert.Error(t, err)
 
 	// Force token refresh and throw an error
-	client.LastRefresh = time.Now().AddDate(0, 0, -1)
+	client.session.lastRefresh = time.Now().AddDate(0, 0, -1)
 	gock.New(testURL).Get("/api/aaaRefresh.json").ReplyError(errors.New("fail"))
-	_, err = client.Post("/url", "{}")
+	_, err = client.Post(ctx, "/url", "{}")
 	assert.Error(t, err)
 }
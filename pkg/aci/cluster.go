@@ -0,0 +1,186 @@
+package aci
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// cluster tracks the APIC controllers serving a fabric and which one is active.
+type cluster struct {
+	mu      sync.Mutex
+	hosts   []string
+	current int
+}
+
+// normalizeHost adds the https:// scheme to a host when no scheme is given.
+func normalizeHost(host string) string {
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "https://" + host
+	}
+	return host
+}
+
+// withPort adds the default HTTPS port to an APIC without a port,
+// e.g. https://10.0.0.1 becomes https://10.0.0.1:443.
+func withPort(host string) string {
+	u, err := url.Parse(host)
+	if err != nil || u.Port() != "" {
+		return host
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), "443")
+	return u.String()
+}
+
+// active returns the APIC currently serving requests.
+func (c *cluster) active() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hosts[c.current]
+}
+
+// size returns the number of known APICs.
+func (c *cluster) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.hosts)
+}
+
+// add adds APICs to the cluster and returns the ones that were not already known.
+func (c *cluster) add(hosts ...string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var added []string
+	for _, host := range hosts {
+		host = normalizeHost(host)
+		known := false
+		for _, h := range c.hosts {
+			if h == host {
+				known = true
+				break
+			}
+		}
+		if !known {
+			c.hosts = append(c.hosts, host)
+			added = append(added, host)
+		}
+	}
+	return added
+}
+
+// failover moves to the next APIC if from is still the active one and returns the active APIC.
+// Concurrent requests failing on the same APIC only advance the cluster once.
+func (c *cluster) failover(from string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hosts[c.current] == from {
+		c.current = (c.current + 1) % len(c.hosts)
+	}
+	return c.hosts[c.current]
+}
+
+// setHost points a request at the given APIC.
+func setHost(httpReq *http.Request, host string) error {
+	u, err := url.Parse(withPort(host))
+	if err != nil {
+		return err
+	}
+	httpReq.URL.Scheme = u.Scheme
+	httpReq.URL.Host = u.Host
+	httpReq.Host = u.Host
+	return nil
+}
+
+// Failover adds standby APICs of the same cluster.
+// Requests move to the next APIC on connection errors or 5xx responses, e.g.
+//
+//	client, _ := NewClient("apic1", "user", "password", Failover("apic2", "apic3"))
+func Failover(hosts ...string) func(*Client) {
+	return func(client *Client) {
+		client.cluster.add(hosts...)
+	}
+}
+
+// Host returns the APIC currently serving requests.
+func (client Client) Host() string {
+	return client.cluster.active()
+}
+
+// Hosts returns all known APICs in the cluster.
+func (client Client) Hosts() []string {
+	client.cluster.mu.Lock()
+	defer client.cluster.mu.Unlock()
+	return append([]string{}, client.cluster.hosts...)
+}
+
+// DiscoverCluster adds the other APICs of the cluster as failover targets.
+// Cluster members are read from infraWiNode and their management addresses from topSystem.
+// Returns the newly discovered APICs.
+func (client *Client) DiscoverCluster(ctx context.Context) ([]string, error) {
+	nodes, err := client.GetClass(ctx, "infraWiNode")
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool)
+	for _, node := range nodes.Array() {
+		members[node.Get("infraWiNode.attributes.id").Str] = true
+	}
+
+	systems, err := client.GetClass(ctx, "topSystem",
+		Query("query-target-filter", `eq(topSystem.role,"controller")`))
+	if err != nil {
+		return nil, err
+	}
+
+	// Known APICs are usually configured by name or with a port, but reported by address
+	known := make(map[string]bool)
+	for _, host := range client.Hosts() {
+		for _, addr := range resolve(ctx, host) {
+			known[addr] = true
+		}
+	}
+
+	var addrs []string
+	for _, system := range systems.Array() {
+		attrs := system.Get("topSystem.attributes")
+		if !members[attrs.Get("id").Str] {
+			continue
+		}
+		for _, field := range []string{"oobMgmtAddr", "inbMgmtAddr"} {
+			addr := attrs.Get(field).Str
+			if addr == "" || addr == "0.0.0.0" {
+				continue
+			}
+			if !slices.ContainsFunc(resolve(ctx, addr), func(addr string) bool { return known[addr] }) {
+				addrs = append(addrs, addr)
+			}
+			break
+		}
+	}
+	return client.cluster.add(addrs...), nil
+}
+
+// resolve returns the IP addresses of an APIC, or its hostname if it cannot be resolved.
+func resolve(ctx context.Context, host string) []string {
+	u, err := url.Parse(normalizeHost(host))
+	if err != nil {
+		return []string{host}
+	}
+	name := u.Hostname()
+	if ip := net.ParseIP(name); ip != nil {
+		return []string{ip.String()}
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", name)
+	if err != nil {
+		return []string{name}
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs
+}
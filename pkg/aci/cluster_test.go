@@ -0,0 +1,136 @@
+package aci
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestFailover tests failing over to standby APICs.
+func TestFailover(t *testing.T) {
+	defer gock.Off()
+	client, _ := NewClient(testHost, "usr", "pwd", Failover("10.0.0.2", "10.0.0.3"))
+	gock.InterceptClient(client.HTTPClient)
+	assert.Equal(t, []string{testURL, "https://10.0.0.2", "https://10.0.0.3"}, client.Hosts())
+
+	// Connection error on the first APIC, 5xx on the second
+	gock.New(testURL).Get("/url.json").ReplyError(errors.New("fail"))
+	gock.New("https://10.0.0.2").Post("/api/aaaLogin.json").Reply(200)
+	gock.New("https://10.0.0.2").Get("/url.json").Reply(502)
+	gock.New("https://10.0.0.3").Post("/api/aaaLogin.json").Reply(200)
+	gock.New("https://10.0.0.3").Get("/url.json").Reply(200)
+	var host string
+	_, err := client.Get(ctx, "/url", ServedBy(&host))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.3", client.Host())
+	assert.Equal(t, "https://10.0.0.3", host)
+	assert.True(t, gock.IsDone())
+
+	// Throttling is left to the retry policy
+	for _, status := range []int{429, 503} {
+		gock.New("https://10.0.0.3").Get("/url.json").Reply(status)
+		_, err = client.Get(ctx, "/url")
+		assert.ErrorIs(t, err, ErrThrottled)
+		assert.Equal(t, "https://10.0.0.3", client.Host())
+		assert.True(t, gock.IsDone())
+	}
+
+	// Client errors do not fail over
+	gock.New("https://10.0.0.3").Get("/url.json").Reply(404)
+	_, err = client.Get(ctx, "/url")
+	assert.Error(t, err)
+	assert.Equal(t, "https://10.0.0.3", client.Host())
+
+	// Every APIC is tried once
+	gock.New("https://10.0.0.3").Get("/url.json").Reply(500)
+	gock.New(testURL).Post("/api/aaaLogin.json").Reply(200)
+	gock.New(testURL).Get("/url.json").Reply(500)
+	gock.New("https://10.0.0.2").Post("/api/aaaLogin.json").Reply(200)
+	gock.New("https://10.0.0.2").Get("/url.json").Reply(500)
+	_, err = client.Get(ctx, "/url")
+	assert.Error(t, err)
+	assert.True(t, gock.IsDone())
+}
+
+// TestDiscoverCluster tests the Client::DiscoverCluster method.
+func TestDiscoverCluster(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+
+	gock.New(testURL).
+		Get("/api/class/infraWiNode.json").
+		Reply(200).
+		BodyString(Body{}.
+			Set("imdata.0.infraWiNode.attributes.id", "1").
+			Set("imdata.1.infraWiNode.attributes.id", "2").
+			Set("imdata.2.infraWiNode.attributes.id", "3").
+			Str)
+	gock.New(testURL).
+		Get("/api/class/topSystem.json").
+		MatchParam("query-target-filter", `eq\(topSystem.role,"controller"\)`).
+		Reply(200).
+		BodyString(Body{}.
+			Set("imdata.0.topSystem.attributes.id", "1").
+			Set("imdata.0.topSystem.attributes.oobMgmtAddr", testHost).
+			Set("imdata.1.topSystem.attributes.id", "2").
+			Set("imdata.1.topSystem.attributes.oobMgmtAddr", "10.0.0.2").
+			Set("imdata.2.topSystem.attributes.id", "3").
+			Set("imdata.2.topSystem.attributes.oobMgmtAddr", "0.0.0.0").
+			Set("imdata.2.topSystem.attributes.inbMgmtAddr", "192.168.0.3").
+			Str)
+
+	added, err := client.DiscoverCluster(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://10.0.0.2", "https://192.168.0.3"}, added)
+	assert.Len(t, client.Hosts(), 3)
+
+	// The active APIC is recognized under its address
+	client, _ = NewClient("localhost:8443", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	gock.New("https://localhost:8443").
+		Get("/api/class/infraWiNode.json").
+		Reply(200).
+		BodyString(Body{}.Set("imdata.0.infraWiNode.attributes.id", "1").Str)
+	gock.New("https://localhost:8443").
+		Get("/api/class/topSystem.json").
+		Reply(200).
+		BodyString(Body{}.
+			Set("imdata.0.topSystem.attributes.id", "1").
+			Set("imdata.0.topSystem.attributes.oobMgmtAddr", "127.0.0.1").
+			Str)
+	added, err = client.DiscoverCluster(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Equal(t, []string{"https://localhost:8443"}, client.Hosts())
+}
+
+// TestFailoverCanceled tests that canceled requests do not fail over.
+func TestFailoverCanceled(t *testing.T) {
+	defer gock.Off()
+	client, _ := NewClient(testHost, "usr", "pwd", Failover("10.0.0.2"))
+	gock.InterceptClient(client.HTTPClient)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := client.Get(canceled, "/url")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, testURL, client.Host())
+}
+
+// TestWithPort tests that requests go to port 443 unless the APIC names another port.
+func TestWithPort(t *testing.T) {
+	client, _ := NewClient("10.0.0.1:8443", "usr", "pwd")
+	req := client.NewReq(ctx, "GET", "/api/class/fvTenant", nil)
+	assert.Equal(t, "https://10.0.0.1:8443/api/class/fvTenant.json", req.HTTPReq.URL.String())
+
+	client, _ = NewClient("apic1", "usr", "pwd")
+	req = client.NewReq(ctx, "GET", "/api/class/fvTenant", nil)
+	assert.Equal(t, "https://apic1:443/api/class/fvTenant.json", req.HTTPReq.URL.String())
+
+	assert.Equal(t, "https://[2001:db8::1]:443", withPort("https://[2001:db8::1]"))
+	assert.NoError(t, setHost(req.HTTPReq, "https://10.0.0.2:4443"))
+	assert.Equal(t, "10.0.0.2:4443", req.HTTPReq.URL.Host)
+}
@@ -0,0 +1,97 @@
+package aci
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors for common APIC failures.
+// An *APIError matches these with errors.Is, e.g.
+//
+//	if errors.Is(err, aci.ErrDatasetTooBig) { ... }
+var (
+	// ErrDatasetTooBig is returned when the response would exceed the APIC's size limit.
+	ErrDatasetTooBig = errors.New("result dataset is too big")
+	// ErrUnauthorized is returned when authentication fails or the token is rejected.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrClassNotFound is returned when the queried class does not exist on the APIC.
+	ErrClassNotFound = errors.New("class not found")
+	// ErrThrottled is returned when the APIC rejects requests due to rate limiting.
+	ErrThrottled = errors.New("throttled")
+	// ErrCountMismatch is returned when paginated results don't add up to totalCount.
+	ErrCountMismatch = errors.New("object count does not match totalCount")
+)
+
+// APIError is an error response from the APIC.
+type APIError struct {
+	// StatusCode is the HTTP status code.
+	StatusCode int
+	// Code is the APIC error code from imdata.0.error.attributes.code.
+	Code string
+	// Text is the APIC error text from imdata.0.error.attributes.text.
+	Text string
+	// RetryAfter is the wait requested by the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+// newAPIError builds an APIError from an HTTP status and response body.
+func newAPIError(statusCode int, res Res) *APIError {
+	attrs := res.Get("imdata.0.error.attributes")
+	return &APIError{
+		StatusCode: statusCode,
+		Code:       attrs.Get("code").Str,
+		Text:       attrs.Get("text").Str,
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+// Returns 0 if the header is missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("received HTTP status %d", e.StatusCode)
+	}
+	if e.Code == "" {
+		return fmt.Sprintf("received HTTP status %d: %s", e.StatusCode, e.Text)
+	}
+	return fmt.Sprintf("received HTTP status %d: APIC error %s: %s", e.StatusCode, e.Code, e.Text)
+}
+
+// Is matches the sentinel errors of this package.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrDatasetTooBig:
+		return e.StatusCode == http.StatusBadRequest &&
+			strings.Contains(e.Text, "result dataset is too big")
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrClassNotFound:
+		return e.StatusCode == http.StatusBadRequest &&
+			(e.Code == "122" || strings.Contains(strings.ToLower(e.Text), "unknown managed object class"))
+	case ErrThrottled:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// tokenInvalid reports whether the APIC rejected an expired or invalid token.
+func (e *APIError) tokenInvalid() bool {
+	return e.StatusCode == http.StatusForbidden && strings.Contains(e.Text, "Token was invalid")
+}
@@ -0,0 +1,77 @@
+package aci
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestAPIError tests matching APIError against the sentinel errors.
+func TestAPIError(t *testing.T) {
+	tooBig := &APIError{StatusCode: 400, Code: "400", Text: "Unable to process the query, result dataset is too big"}
+	assert.ErrorIs(t, tooBig, ErrDatasetTooBig)
+	assert.NotErrorIs(t, tooBig, ErrClassNotFound)
+
+	notFound := &APIError{StatusCode: 400, Code: "122", Text: "unknown managed object class fooBar"}
+	assert.ErrorIs(t, notFound, ErrClassNotFound)
+	assert.NotErrorIs(t, notFound, ErrDatasetTooBig)
+
+	assert.ErrorIs(t, &APIError{StatusCode: 401}, ErrUnauthorized)
+	assert.ErrorIs(t, &APIError{StatusCode: 403}, ErrUnauthorized)
+	assert.ErrorIs(t, &APIError{StatusCode: 429}, ErrThrottled)
+	assert.ErrorIs(t, &APIError{StatusCode: 503}, ErrThrottled)
+	assert.NotErrorIs(t, &APIError{StatusCode: 500}, ErrThrottled)
+
+	assert.Equal(t, "received HTTP status 500", (&APIError{StatusCode: 500}).Error())
+	assert.Equal(t, "received HTTP status 400: APIC error 122: unknown managed object class fooBar",
+		notFound.Error())
+}
+
+// TestClientAPIError tests that Client::Do returns an APIError.
+func TestClientAPIError(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+
+	gock.New(testURL).
+		Get("/api/class/fooBar.json").
+		Reply(400).
+		BodyString(Body{}.
+			Set("imdata.0.error.attributes.code", "122").
+			Set("imdata.0.error.attributes.text", "unknown managed object class fooBar").
+			Str)
+	_, err := client.GetClass(ctx, "fooBar")
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 400, apiErr.StatusCode)
+	assert.Equal(t, "122", apiErr.Code)
+	assert.ErrorIs(t, err, ErrClassNotFound)
+
+	// Failed login
+	gock.New(testURL).
+		Post("/api/aaaLogin.json").
+		Reply(401).
+		BodyString(Body{}.Set("imdata.0.error.attributes.text", "Username or password is incorrect").Str)
+	assert.ErrorIs(t, client.Login(ctx), ErrUnauthorized)
+
+	// Throttled with Retry-After
+	gock.New(testURL).
+		Get("/api/class/fvBD.json").
+		Reply(429).
+		SetHeader("Retry-After", "30")
+	_, err = client.GetClass(ctx, "fvBD")
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 30*time.Second, apiErr.RetryAfter)
+}
+
+// TestParseRetryAfter tests parsing the Retry-After header.
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Mon, 01 Jan 2024 00:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Sun, 31 Dec 2023 00:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
@@ -0,0 +1,179 @@
+package aci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PageCount returns the number of pages needed for totalCount objects.
+func PageCount(totalCount, pageSize int) int {
+	if pageSize <= 0 {
+		return 0
+	}
+	return (totalCount + pageSize - 1) / pageSize
+}
+
+// Count returns the number of objects a query would return without fetching them, e.g.
+//
+//	n, err := client.Count(ctx, "/api/class/fvRsPathAtt")
+func (client *Client) Count(ctx context.Context, path string, mods ...func(*Req)) (int, error) {
+	mods = append(mods[:len(mods):len(mods)], Query("rsp-subtree-include", "count"))
+	res, err := client.Get(ctx, path, mods...)
+	if err != nil {
+		return 0, err
+	}
+	count := res.Get("imdata.0.moCount.attributes.count")
+	if !count.Exists() {
+		return 0, errors.New("moCount missing from response")
+	}
+	n, err := strconv.Atoi(count.Str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid count: %v", err)
+	}
+	return n, nil
+}
+
+// Pagination describes the requests of a paginated query and checks their responses.
+// GetPages fetches the pages one after the other; callers can also fetch them
+// independently, e.g. concurrently with retries, and check each page with it.
+type Pagination struct {
+	path     string
+	pageSize int
+	mods     []func(*Req)
+}
+
+// NewPagination prepares a paginated query.
+// Class queries are ordered by dn so objects don't shift between pages,
+// unless the modifiers set their own order-by, which is kept.
+func NewPagination(path string, pageSize int, mods ...func(*Req)) (Pagination, error) {
+	if pageSize <= 0 {
+		return Pagination{}, fmt.Errorf("invalid page size: %d", pageSize)
+	}
+	mods = mods[:len(mods):len(mods)]
+	if class, ok := strings.CutPrefix(path, "/api/class/"); ok && !setsQuery(mods, "order-by") {
+		mods = append(mods, OrderBy(class+".dn"))
+	}
+	return Pagination{path: path, pageSize: pageSize, mods: mods}, nil
+}
+
+// setsQuery reports whether the modifiers set a query parameter.
+func setsQuery(mods []func(*Req), key string) bool {
+	req := Req{HTTPReq: &http.Request{URL: &url.URL{}}}
+	for _, mod := range mods {
+		mod(&req)
+	}
+	return req.HTTPReq.URL.Query().Has(key)
+}
+
+// PageSize returns the number of objects per page.
+func (p Pagination) PageSize() int {
+	return p.pageSize
+}
+
+// Page returns the modifiers requesting a page, zero based.
+func (p Pagination) Page(page int) []func(*Req) {
+	return append(p.mods[:len(p.mods):len(p.mods)], Page(page, p.pageSize))
+}
+
+// Objects checks a page and returns the number of objects in it.
+func (p Pagination) Objects(res Res) (int, error) {
+	if !res.Get("imdata").IsArray() {
+		return 0, errors.New("imdata is not an array")
+	}
+	return len(res.Get("imdata").Array()), nil
+}
+
+// Pages reads the totalCount of the first page and returns it with the number of pages.
+// There is always at least one page.
+func (p Pagination) Pages(first Res) (totalCount, pages int, err error) {
+	totalCount, err = strconv.Atoi(first.Get("totalCount").Str)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid totalCount: %v", err)
+	}
+	return totalCount, max(PageCount(totalCount, p.pageSize), 1), nil
+}
+
+// Verify compares the number of objects received against the totalCount of the first page,
+// returning an error wrapping ErrCountMismatch on a difference.
+func (p Pagination) Verify(received, totalCount int) error {
+	if received != totalCount {
+		return fmt.Errorf("%w: received %d of %d objects for %s",
+			ErrCountMismatch, received, totalCount, p.path)
+	}
+	return nil
+}
+
+// GetPages makes paginated GET requests and iterates over the pages.
+// Each page is the raw response as returned by Get, including totalCount.
+// Pages are ordered as described for NewPagination.
+// After the last page the number of objects received is verified against the
+// totalCount of the first page, yielding an error wrapping ErrCountMismatch on a difference, e.g.
+//
+//	for page, err := range client.GetPages(ctx, "/api/class/fvRsPathAtt", 1000) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(len(page.Get("imdata").Array()))
+//	}
+func (client *Client) GetPages(ctx context.Context, path string, pageSize int, mods ...func(*Req)) iter.Seq2[Res, error] {
+	return func(yield func(Res, error) bool) {
+		p, err := NewPagination(path, pageSize, mods...)
+		if err != nil {
+			yield(Res{}, err)
+			return
+		}
+
+		totalCount, pages, received := 0, 1, 0
+		for page := 0; page < pages; page++ {
+			res, err := client.Get(ctx, path, p.Page(page)...)
+			if err != nil {
+				yield(Res{}, err)
+				return
+			}
+			n, err := p.Objects(res)
+			if err != nil {
+				yield(Res{}, err)
+				return
+			}
+			if page == 0 {
+				totalCount, pages, err = p.Pages(res)
+				if err != nil {
+					yield(Res{}, err)
+					return
+				}
+			}
+			received += n
+			if !yield(res, nil) {
+				return
+			}
+		}
+		if err := p.Verify(received, totalCount); err != nil {
+			yield(Res{}, err)
+		}
+	}
+}
+
+// GetObjects makes paginated GET requests and iterates over the individual objects.
+// Objects are wrapped in their class as in imdata, e.g. {"fvTenant":{"attributes":{...}}}.
+// See GetPages for ordering and count verification.
+func (client *Client) GetObjects(ctx context.Context, path string, pageSize int, mods ...func(*Req)) iter.Seq2[Res, error] {
+	return func(yield func(Res, error) bool) {
+		for page, err := range client.GetPages(ctx, path, pageSize, mods...) {
+			if err != nil {
+				yield(Res{}, err)
+				return
+			}
+			for _, obj := range page.Get("imdata").Array() {
+				if !yield(obj, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package aci
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// mockPage mocks a page of a paginated fvBD query.
+func mockPage(page, size, totalCount, objects int) {
+	body := Body{}.Set("totalCount", strconv.Itoa(totalCount)).SetRaw("imdata", "[]")
+	for i := 0; i < objects; i++ {
+		body = body.Set(fmt.Sprintf("imdata.%d.fvBD.attributes.dn", i), fmt.Sprintf("bd-%d", page*size+i))
+	}
+	gock.New(testURL).
+		Get("/api/class/fvBD.json").
+		MatchParam("page", strconv.Itoa(page)).
+		MatchParam("page-size", strconv.Itoa(size)).
+		MatchParam("order-by", `fvBD.dn\|asc`).
+		Reply(200).
+		BodyString(body.Str)
+}
+
+// TestPageCount tests the PageCount function.
+func TestPageCount(t *testing.T) {
+	assert.Equal(t, 0, PageCount(0, 10))
+	assert.Equal(t, 1, PageCount(10, 10))
+	assert.Equal(t, 2, PageCount(11, 10))
+	assert.Equal(t, 0, PageCount(11, 0))
+}
+
+// TestGetObjects tests the Client::GetObjects method.
+func TestGetObjects(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+
+	// Final partial page is included
+	mockPage(0, 2, 5, 2)
+	mockPage(1, 2, 5, 2)
+	mockPage(2, 2, 5, 1)
+	var dns []string
+	for obj, err := range client.GetObjects(ctx, "/api/class/fvBD", 2) {
+		assert.NoError(t, err)
+		dns = append(dns, obj.Get("fvBD.attributes.dn").Str)
+	}
+	assert.Equal(t, []string{"bd-0", "bd-1", "bd-2", "bd-3", "bd-4"}, dns)
+	assert.True(t, gock.IsDone())
+
+	// Empty result
+	mockPage(0, 2, 0, 0)
+	for _, err := range client.GetObjects(ctx, "/api/class/fvBD", 2) {
+		assert.NoError(t, err)
+	}
+	assert.True(t, gock.IsDone())
+}
+
+// TestGetPages tests the Client::GetPages method.
+func TestGetPages(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+
+	// Objects lost between pages are detected
+	mockPage(0, 2, 4, 2)
+	mockPage(1, 2, 4, 1)
+	var pages int
+	var lastErr error
+	for _, err := range client.GetPages(ctx, "/api/class/fvBD", 2) {
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		pages++
+	}
+	assert.Equal(t, 2, pages)
+	assert.ErrorIs(t, lastErr, ErrCountMismatch)
+
+	// Stopping early makes no further requests
+	mockPage(0, 2, 4, 2)
+	for range client.GetPages(ctx, "/api/class/fvBD", 2) {
+		break
+	}
+	assert.True(t, gock.IsDone())
+
+	// Invalid page size
+	for _, err := range client.GetPages(ctx, "/api/class/fvBD", 0) {
+		assert.Error(t, err)
+	}
+}
+
+// TestPagination tests the order of paginated queries.
+func TestPagination(t *testing.T) {
+	client := testClient()
+	orderBy := func(mods ...func(*Req)) []string {
+		p, err := NewPagination("/api/class/fvBD", 2, mods...)
+		assert.NoError(t, err)
+		return client.NewReq(ctx, "GET", "/api/class/fvBD", nil, p.Page(1)...).HTTPReq.URL.Query()["order-by"]
+	}
+
+	// Class queries are ordered by dn unless the caller sets its own order
+	assert.Equal(t, []string{"fvBD.dn|asc"}, orderBy())
+	assert.Equal(t, []string{"fvBD.name|desc"}, orderBy(Query("order-by", "fvBD.name|desc")))
+	assert.Equal(t, []string{"fvBD.name|asc"}, orderBy(OrderBy("fvBD.name")))
+}
+
+// TestCount tests the Client::Count method.
+func TestCount(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+
+	gock.New(testURL).
+		Get("/api/class/fvBD.json").
+		MatchParam("rsp-subtree-include", "count").
+		Reply(200).
+		BodyString(`{"totalCount":"1","imdata":[{"moCount":{"attributes":{"count":"1234"}}}]}`)
+	n, err := client.Count(ctx, "/api/class/fvBD")
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, n)
+
+	// Response without a count
+	gock.New(testURL).
+		Get("/api/class/fvBD.json").
+		Reply(200).
+		BodyString(`{"totalCount":"0","imdata":[]}`)
+	_, err = client.Count(ctx, "/api/class/fvBD")
+	assert.Error(t, err)
+	assert.True(t, gock.IsDone())
+}
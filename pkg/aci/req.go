@@ -2,6 +2,7 @@ package aci
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
@@ -44,6 +45,8 @@ type Req struct {
 	// Refresh indicates whether token refresh should be checked for this request.
 	// Pass NoRefresh to disable Refresh check.
 	Refresh bool
+	// servedBy receives the APIC that answered the request, if set.
+	servedBy *string
 }
 
 // NoRefresh prevents token refresh check.
@@ -52,13 +55,24 @@ func NoRefresh(req *Req) {
 	req.Refresh = false
 }
 
+// ServedBy records the APIC that answered the request, which differs from the
+// APIC active when the request was made if the client failed over, e.g.
+//
+//	var host string
+//	client.GetClass(ctx, "fvBD", aci.ServedBy(&host))
+func ServedBy(host *string) func(req *Req) {
+	return func(req *Req) {
+		req.servedBy = host
+	}
+}
+
 // Query sets an HTTP query parameter.
 //
-//	client.GetClass("fvBD", aci.Query("query-target-filter", `eq(fvBD.name,"bd-name")`))
+//	client.GetClass(ctx, "fvBD", aci.Query("query-target-filter", `eq(fvBD.name,"bd-name")`))
 //
 // Or set multiple parameters:
 //
-//	client.GetClass("fvBD",
+//	client.GetClass(ctx, "fvBD",
 //	  aci.Query("rsp-subtree-include", "faults"),
 //	  aci.Query("query-target-filter", `eq(fvBD.name,"bd-name")`))
 func Query(k, v string) func(req *Req) {
@@ -68,3 +82,27 @@ func Query(k, v string) func(req *Req) {
 		req.HTTPReq.URL.RawQuery = q.Encode()
 	}
 }
+
+// Page requests a single page of a paginated query.
+// Pages are zero based; repeated use replaces rather than adds the parameters.
+//
+//	client.GetClass(ctx, "fvRsPathAtt", aci.Page(2, 1000))
+func Page(number, size int) func(req *Req) {
+	return func(req *Req) {
+		q := req.HTTPReq.URL.Query()
+		q.Set("page", strconv.Itoa(number))
+		q.Set("page-size", strconv.Itoa(size))
+		req.HTTPReq.URL.RawQuery = q.Encode()
+	}
+}
+
+// OrderBy sorts results ascending by a class property, e.g.
+//
+//	client.GetClass(ctx, "fvBD", aci.OrderBy("fvBD.dn"))
+func OrderBy(property string) func(req *Req) {
+	return func(req *Req) {
+		q := req.HTTPReq.URL.Query()
+		q.Set("order-by", property+"|asc")
+		req.HTTPReq.URL.RawQuery = q.Encode()
+	}
+}
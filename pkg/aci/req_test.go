@@ -19,11 +19,11 @@ func TestQuery(t *testing.T) {
 	client := testClient()
 
 	gock.New(testURL).Get("/url").MatchParam("foo", "bar").Reply(200)
-	_, err := client.Get("/url", Query("foo", "bar"))
+	_, err := client.Get(ctx, "/url", Query("foo", "bar"))
 	assert.NoError(t, err)
 
 	// Test case for comma-separated parameters
 	gock.New(testURL).Get("/url").MatchParam("foo", "bar,baz").Reply(200)
-	_, err = client.Get("/url", Query("foo", "bar,baz"))
+	_, err = client.Get(ctx, "/url", Query("foo", "bar,baz"))
 	assert.NoError(t, err)
 }
@@ -0,0 +1,107 @@
+package aci
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultRefreshInterval is used when the APIC does not report refreshTimeoutSeconds.
+const defaultRefreshInterval = 480 * time.Second
+
+// session is the authentication state shared by all copies of a Client.
+type session struct {
+	mu sync.Mutex
+	// token is the current authentication token.
+	token string
+	// lastRefresh is the time the token was obtained or last refreshed.
+	lastRefresh time.Time
+	// interval is the time after which the token is refreshed.
+	interval time.Duration
+	// generation is incremented whenever a new token is obtained.
+	generation uint64
+	// flight deduplicates concurrent logins and refreshes.
+	flight singleflight.Group
+}
+
+// update stores a new token.
+// The refresh interval is 80% of the token lifetime reported by the APIC.
+func (s *session) update(token, refreshTimeout string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	s.lastRefresh = time.Now()
+	s.generation++
+	s.interval = defaultRefreshInterval
+	if seconds, err := strconv.Atoi(refreshTimeout); err == nil && seconds > 0 {
+		s.interval = time.Duration(seconds) * time.Second * 4 / 5
+	}
+}
+
+// refreshDue reports whether the token should be refreshed.
+// A session that never logged in has nothing to refresh.
+func (s *session) refreshDue() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.lastRefresh.IsZero() && time.Since(s.lastRefresh) > s.interval
+}
+
+// gen returns the current token generation.
+func (s *session) gen() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation
+}
+
+// Token returns the current authentication token.
+func (client Client) Token() string {
+	client.session.mu.Lock()
+	defer client.session.mu.Unlock()
+	return client.session.token
+}
+
+// LastRefresh returns the time the token was obtained or last refreshed.
+func (client Client) LastRefresh() time.Time {
+	client.session.mu.Lock()
+	defer client.session.mu.Unlock()
+	return client.session.lastRefresh
+}
+
+// RefreshInterval returns the time after which the token is refreshed.
+func (client Client) RefreshInterval() time.Duration {
+	client.session.mu.Lock()
+	defer client.session.mu.Unlock()
+	return client.session.interval
+}
+
+// refreshIfDue refreshes the token once for all concurrent callers when it is due.
+// A failed refresh, e.g. after the token already expired, falls back to logging in again.
+func (client *Client) refreshIfDue(ctx context.Context) error {
+	_, err, _ := client.session.flight.Do("refresh", func() (any, error) {
+		if !client.session.refreshDue() {
+			return nil, nil
+		}
+		if err := client.Refresh(ctx); err != nil {
+			client.logger.Warn().Err(err).Msg("token refresh failed, logging in again")
+			return nil, client.Login(ctx)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// relogin logs in again once for all concurrent callers.
+// Callers pass the token generation their request used; if another caller has
+// obtained a new token since, no login is needed.
+func (client *Client) relogin(ctx context.Context, generation uint64) error {
+	_, err, _ := client.session.flight.Do("login", func() (any, error) {
+		if client.session.gen() != generation {
+			return nil, nil
+		}
+		return nil, client.Login(ctx)
+	})
+	return err
+}
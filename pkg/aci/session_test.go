@@ -0,0 +1,84 @@
+package aci
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestSessionInterval tests the refresh interval derived from aaaLogin.
+func TestSessionInterval(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+
+	gock.New(testURL).
+		Post("/api/aaaLogin.json").
+		Reply(200).
+		BodyString(Body{}.
+			Set("imdata.0.aaaLogin.attributes.token", "abc").
+			Set("imdata.0.aaaLogin.attributes.refreshTimeoutSeconds", "300").
+			Str)
+	assert.NoError(t, client.Login(ctx))
+	assert.Equal(t, "abc", client.Token())
+	assert.Equal(t, 240*time.Second, client.RefreshInterval())
+
+	// Copies share the session
+	clone := client
+	assert.Equal(t, client.LastRefresh(), clone.LastRefresh())
+}
+
+// TestSessionSharedRefresh tests that concurrent requests refresh the token once.
+func TestSessionSharedRefresh(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+	client.session.lastRefresh = time.Now().AddDate(0, 0, -1)
+
+	gock.New(testURL).
+		Get("/api/aaaRefresh.json").
+		Times(1).
+		Reply(200).
+		BodyString(Body{}.Set("imdata.0.aaaRefresh.attributes.token", "new").Str)
+	gock.New(testURL).Get("/url.json").Times(5).Reply(200)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func(client Client) {
+			defer wg.Done()
+			_, err := client.Get(ctx, "/url")
+			assert.NoError(t, err)
+		}(client)
+	}
+	wg.Wait()
+	assert.Equal(t, "new", client.Token())
+	assert.True(t, gock.IsDone())
+}
+
+// TestSessionTokenInvalid tests logging in again when the APIC rejects the token.
+func TestSessionTokenInvalid(t *testing.T) {
+	defer gock.Off()
+	client := testClient()
+
+	gock.New(testURL).
+		Get("/url.json").
+		Reply(403).
+		BodyString(Body{}.Set("imdata.0.error.attributes.text", "Token was invalid (Error: Token timeout)").Str)
+	gock.New(testURL).
+		Post("/api/aaaLogin.json").
+		Reply(200).
+		BodyString(Body{}.Set("imdata.0.aaaLogin.attributes.token", "fresh").Str)
+	gock.New(testURL).Get("/url.json").Reply(200)
+
+	_, err := client.Get(ctx, "/url")
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", client.Token())
+	assert.True(t, gock.IsDone())
+
+	// Other 403 errors are not retried
+	gock.New(testURL).Get("/url.json").Reply(403)
+	_, err = client.Get(ctx, "/url")
+	assert.Error(t, err)
+}
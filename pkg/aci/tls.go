@@ -0,0 +1,202 @@
+package aci
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// TLSOptions configures verification of the APIC server certificate.
+// By default the client skips verification entirely.
+type TLSOptions struct {
+	// Verify enables certificate chain and hostname verification.
+	Verify bool
+	// RootCAs is the CA pool used for chain verification; nil uses the system roots.
+	RootCAs *x509.CertPool
+	// Fingerprint pins the SHA-256 fingerprints of the APIC leaf certificates,
+	// separated by commas as each APIC of a cluster has its own certificate.
+	// A matching pin is sufficient unless RootCAs is also set.
+	Fingerprint string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS12.
+	MinVersion uint16
+	// TrustOnFirstUse accepts the first certificate seen on each APIC when no Fingerprint
+	// is set and pins it for the remaining connections to that APIC.
+	TrustOnFirstUse bool
+	// OnFirstUse is called with the fingerprint accepted by TrustOnFirstUse.
+	// It is called during the TLS handshake and should return quickly.
+	OnFirstUse func(fingerprint string)
+}
+
+// tlsState tracks the certificate fingerprints pinned by a client.
+type tlsState struct {
+	mu sync.Mutex
+	// pinned are the fingerprints the APICs must present, if any.
+	pinned []string
+	// firstUse are the fingerprints pinned by trust-on-first-use, by APIC address.
+	firstUse map[string]string
+}
+
+// TLS configures server certificate verification, e.g.
+//
+//	client, _ := NewClient("apic", "user", "password", TLS(TLSOptions{Fingerprint: "ab12..."}))
+func TLS(opts TLSOptions) func(*Client) {
+	return func(client *Client) {
+		tr, ok := client.HTTPClient.Transport.(*http.Transport)
+		if !ok {
+			return
+		}
+		state := client.tls
+		state.pinned = nil
+		for _, fingerprint := range strings.Split(opts.Fingerprint, ",") {
+			if fingerprint = NormalizeFingerprint(fingerprint); fingerprint != "" {
+				state.pinned = append(state.pinned, fingerprint)
+			}
+		}
+		cfg := &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         opts.MinVersion,
+		}
+		tr.TLSClientConfig = cfg
+		tr.DialTLSContext = nil
+		if !opts.Verify && len(state.pinned) == 0 && !opts.TrustOnFirstUse {
+			return
+		}
+
+		// Connections are verified in the dialer, which knows the APIC they are made to
+		tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			conn := cfg.Clone()
+			conn.ServerName = host
+			conn.VerifyConnection = func(cs tls.ConnectionState) error {
+				return state.verify(addr, host, cs, opts)
+			}
+			dialer := tls.Dialer{Config: conn}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+}
+
+// verify checks a TLS connection to the APIC at addr against the pins and/or CA pool.
+func (state *tlsState) verify(addr, host string, cs tls.ConnectionState, opts TLSOptions) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("APIC presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	fingerprint := Fingerprint(leaf)
+
+	state.mu.Lock()
+	pinned := state.pinned
+	firstUse := false
+	if len(pinned) == 0 && opts.TrustOnFirstUse {
+		if state.firstUse == nil {
+			state.firstUse = make(map[string]string)
+		}
+		if trusted, ok := state.firstUse[addr]; ok {
+			pinned = []string{trusted}
+		} else {
+			state.firstUse[addr] = fingerprint
+			firstUse = true
+		}
+	}
+	state.mu.Unlock()
+
+	if firstUse {
+		if opts.OnFirstUse != nil {
+			opts.OnFirstUse(fingerprint)
+		}
+		return nil
+	}
+	if len(pinned) > 0 {
+		if !slices.Contains(pinned, fingerprint) {
+			return fmt.Errorf("APIC certificate fingerprint %s does not match pinned fingerprint %s",
+				fingerprint, strings.Join(pinned, ", "))
+		}
+		if opts.RootCAs == nil {
+			return nil
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         opts.RootCAs,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// TrustedOnFirstUse returns the fingerprints pinned by TrustOnFirstUse, one per APIC.
+// It is empty if no certificate was trusted on first use.
+func (client Client) TrustedOnFirstUse() []string {
+	client.tls.mu.Lock()
+	defer client.tls.mu.Unlock()
+	var fingerprints []string
+	for _, fingerprint := range client.tls.firstUse {
+		if !slices.Contains(fingerprints, fingerprint) {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	slices.Sort(fingerprints)
+	return fingerprints
+}
+
+// Fingerprint returns the lowercase hex SHA-256 fingerprint of a certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint lowercases a fingerprint and strips separators,
+// so that "AB:CD:..." and "abcd..." compare equal.
+func NormalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.ReplaceAll(fingerprint, ":", "")
+	fingerprint = strings.ReplaceAll(fingerprint, " ", "")
+	return strings.ToLower(fingerprint)
+}
+
+// LoadCABundle reads a PEM file of CA certificates into a pool.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// ParseTLSVersion converts a version string such as "1.2" to a tls.Version* constant.
+// An empty string returns 0, i.e. the Go default.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "":
+		return 0, nil
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version: %s", version)
+}
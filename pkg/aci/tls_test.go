@@ -0,0 +1,141 @@
+package aci
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dialTLS opens a TLS connection to the test server using the client's TLS settings.
+func dialTLS(client Client, srv *httptest.Server) error {
+	tr := client.HTTPClient.Transport.(*http.Transport)
+	addr := srv.Listener.Addr().String()
+	var conn net.Conn
+	var err error
+	if tr.DialTLSContext != nil {
+		conn, err = tr.DialTLSContext(context.Background(), "tcp", addr)
+	} else {
+		conn, err = tls.Dial("tcp", addr, tr.TLSClientConfig)
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// newTLSServer starts a test server with its own self-signed certificate,
+// unlike httptest.NewTLSServer which shares one certificate between servers.
+func newTLSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestTLS tests the TLS client modifier.
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	fingerprint := Fingerprint(srv.Certificate())
+
+	// Default skips verification
+	client, _ := NewClient(testHost, "usr", "pwd")
+	assert.NoError(t, dialTLS(client, srv))
+
+	// Verification against system roots fails for the test certificate
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{Verify: true}))
+	assert.Error(t, dialTLS(client, srv))
+
+	// Verification against a CA bundle
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{Verify: true, RootCAs: pool}))
+	assert.NoError(t, dialTLS(client, srv))
+
+	// Matching and mismatched pins
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{Fingerprint: fingerprint}))
+	assert.NoError(t, dialTLS(client, srv))
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{Fingerprint: "00:11"}))
+	assert.Error(t, dialTLS(client, srv))
+
+	// Trust on first use reports the fingerprint once
+	var seen []string
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{
+		TrustOnFirstUse: true,
+		OnFirstUse:      func(fp string) { seen = append(seen, fp) },
+	}))
+	assert.Empty(t, client.TrustedOnFirstUse())
+	assert.NoError(t, dialTLS(client, srv))
+	assert.NoError(t, dialTLS(client, srv))
+	assert.Equal(t, []string{fingerprint}, seen)
+	assert.Equal(t, []string{fingerprint}, client.TrustedOnFirstUse())
+
+	// Minimum version
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{MinVersion: tls.VersionTLS13}))
+	assert.Equal(t, uint16(tls.VersionTLS13),
+		client.HTTPClient.Transport.(*http.Transport).TLSClientConfig.MinVersion)
+}
+
+// TestTLSCluster tests pinning the certificates of several APICs.
+func TestTLSCluster(t *testing.T) {
+	srv1, srv2 := newTLSServer(t), newTLSServer(t)
+	fingerprint1, fingerprint2 := Fingerprint(srv1.Certificate()), Fingerprint(srv2.Certificate())
+
+	// Any fingerprint of the list matches
+	client, _ := NewClient(testHost, "usr", "pwd", TLS(TLSOptions{Fingerprint: fingerprint1 + ", " + fingerprint2}))
+	assert.NoError(t, dialTLS(client, srv1))
+	assert.NoError(t, dialTLS(client, srv2))
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{Fingerprint: fingerprint1}))
+	assert.NoError(t, dialTLS(client, srv1))
+	assert.Error(t, dialTLS(client, srv2))
+
+	// Trust on first use pins each APIC
+	client, _ = NewClient(testHost, "usr", "pwd", TLS(TLSOptions{TrustOnFirstUse: true}))
+	assert.NoError(t, dialTLS(client, srv1))
+	assert.NoError(t, dialTLS(client, srv2))
+	assert.NoError(t, dialTLS(client, srv1))
+	assert.ElementsMatch(t, []string{fingerprint1, fingerprint2}, client.TrustedOnFirstUse())
+}
+
+// TestParseTLSVersion tests the ParseTLSVersion function.
+func TestParseTLSVersion(t *testing.T) {
+	v, err := ParseTLSVersion("1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v)
+	v, err = ParseTLSVersion("TLS1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+	_, err = ParseTLSVersion("2.0")
+	assert.Error(t, err)
+}
+
+// TestNormalizeFingerprint tests the NormalizeFingerprint function.
+func TestNormalizeFingerprint(t *testing.T) {
+	assert.Equal(t, "abcd01", NormalizeFingerprint("AB:CD:01"))
+}
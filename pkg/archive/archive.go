@@ -50,6 +50,9 @@ func (a FileWriter) Add(name string, content []byte) error {
 	if err != nil {
 		return nil
 	}
-	_, err = f.Write(content)
-	return err
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+	// Write through, so that an archive left unclosed by a crash can be salvaged
+	return a.zw.Flush()
 }
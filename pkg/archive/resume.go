@@ -0,0 +1,196 @@
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	localHeaderSignature    = 0x04034b50
+	dataDescriptorSignature = 0x08074b50
+	// flagDataDescriptor marks entries whose sizes and CRC follow the data.
+	flagDataDescriptor = 0x8
+)
+
+// entry is a file read from an existing archive.
+type entry struct {
+	name    string
+	content []byte
+}
+
+// Resume creates a new archive at name that carries over files of the existing archive.
+// keep receives the names of all readable files of the existing archive and returns
+// the names to carry over.
+// An archive that was never closed, e.g. after a crash, has no central directory;
+// its files are salvaged up to the first incomplete one.
+// The existing archive is moved to name.resume until its files have been copied,
+// so Resume can itself be resumed if it is interrupted.
+func Resume(name string, keep func(names []string) []string) (Writer, error) {
+	prev := name + ".resume"
+	if _, err := os.Stat(prev); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(name, prev); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := readEntries(prev)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read archive %s: %w", name, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	kept := make(map[string]bool)
+	for _, name := range keep(names) {
+		kept[name] = true
+	}
+
+	w, err := NewWriter(name)
+	if err != nil {
+		return nil, err
+	}
+	fw := w.(FileWriter)
+	for _, e := range entries {
+		if !kept[e.name] {
+			continue
+		}
+		if err := w.Add(e.name, e.content); err != nil {
+			w.Close()
+			return nil, err
+		}
+		kept[e.name] = false
+	}
+
+	// Carried over files are salvageable from the new archive from here on
+	if err := fw.zw.Flush(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := fw.file.Sync(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := os.Remove(prev); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// readEntries reads the files of an archive, salvaging what it can if the archive is incomplete.
+func readEntries(path string) ([]entry, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return salvage(path)
+	}
+	defer r.Close()
+	var entries []entry
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		entries = append(entries, entry{name: f.Name, content: content})
+	}
+	return entries, nil
+}
+
+// salvage reads the files of an archive without central directory from their local headers.
+// Reading stops at the first file that is truncated or fails its checksum.
+func salvage(path string) ([]entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// flate reads byte by byte from a bufio.Reader, so it never reads past the compressed data
+	br := bufio.NewReader(f)
+	var entries []entry
+	for {
+		e, err := readLocalEntry(br)
+		if err != nil {
+			return entries, nil
+		}
+		entries = append(entries, e)
+	}
+}
+
+// readLocalEntry reads a file starting at its local header.
+func readLocalEntry(br *bufio.Reader) (entry, error) {
+	var hdr struct {
+		Signature      uint32
+		Version        uint16
+		Flags          uint16
+		Method         uint16
+		ModTime        uint16
+		ModDate        uint16
+		CRC32          uint32
+		CompressedSize uint32
+		Size           uint32
+		NameLen        uint16
+		ExtraLen       uint16
+	}
+	if err := binary.Read(br, binary.LittleEndian, &hdr); err != nil {
+		return entry{}, err
+	}
+	if hdr.Signature != localHeaderSignature {
+		return entry{}, errors.New("not a local file header")
+	}
+	name := make([]byte, hdr.NameLen)
+	if _, err := io.ReadFull(br, name); err != nil {
+		return entry{}, err
+	}
+	if _, err := br.Discard(int(hdr.ExtraLen)); err != nil {
+		return entry{}, err
+	}
+
+	var content []byte
+	var err error
+	switch {
+	case hdr.Method == zip.Deflate:
+		content, err = io.ReadAll(flate.NewReader(br))
+	case hdr.Method == zip.Store && hdr.Flags&flagDataDescriptor == 0:
+		content = make([]byte, hdr.Size)
+		_, err = io.ReadFull(br, content)
+	default:
+		err = fmt.Errorf("unsupported compression method %d", hdr.Method)
+	}
+	if err != nil {
+		return entry{}, err
+	}
+
+	crc := hdr.CRC32
+	if hdr.Flags&flagDataDescriptor != 0 {
+		var desc [12]byte
+		if _, err := io.ReadFull(br, desc[:4]); err != nil {
+			return entry{}, err
+		}
+		// The descriptor signature is optional
+		n := 0
+		if binary.LittleEndian.Uint32(desc[:4]) != dataDescriptorSignature {
+			n = 4
+		}
+		if _, err := io.ReadFull(br, desc[n:]); err != nil {
+			return entry{}, err
+		}
+		crc = binary.LittleEndian.Uint32(desc[:4])
+	}
+	if crc32.ChecksumIEEE(content) != crc {
+		return entry{}, errors.New("checksum mismatch")
+	}
+	return entry{name: string(name), content: content}, nil
+}
@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readZip returns the files of a zip archive.
+func readZip(t *testing.T, path string) map[string]string {
+	r, err := zip.OpenReader(path)
+	assert.NoError(t, err)
+	defer r.Close()
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestResume(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "test.zip")
+
+	w, _ := NewWriter(path)
+	a.NoError(w.Add("fvTenant.json", []byte(`{"imdata":[]}`)))
+	a.NoError(w.Add("fvBD.json", []byte(`{"imdata":[1]}`)))
+	a.NoError(w.Add("status.json", []byte(`{}`)))
+	a.NoError(w.Close())
+
+	var seen []string
+	w, err := Resume(path, func(names []string) []string {
+		seen = names
+		return []string{"fvTenant.json", "fvBD.json"}
+	})
+	a.NoError(err)
+	sort.Strings(seen)
+	a.Equal([]string{"fvBD.json", "fvTenant.json", "status.json"}, seen)
+	a.NoError(w.Add("fvCtx.json", []byte(`{"imdata":[2]}`)))
+	a.NoError(w.Close())
+
+	a.Equal(map[string]string{
+		"fvTenant.json": `{"imdata":[]}`,
+		"fvBD.json":     `{"imdata":[1]}`,
+		"fvCtx.json":    `{"imdata":[2]}`,
+	}, readZip(t, path))
+	_, err = os.Stat(path + ".resume")
+	a.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestResumeSalvage(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "test.zip")
+
+	// An archive that was never closed has no central directory
+	w, _ := NewWriter(path)
+	fw := w.(FileWriter)
+	a.NoError(w.Add("fvTenant.json", []byte(`{"imdata":[]}`)))
+	a.NoError(w.Add("fvBD.json", []byte(`{"imdata":[1]}`)))
+	a.NoError(fw.zw.Flush())
+	a.NoError(fw.file.Close())
+
+	// A truncated last file is dropped
+	stat, _ := os.Stat(path)
+	a.NoError(os.Truncate(path, stat.Size()-5))
+
+	w, err := Resume(path, func(names []string) []string {
+		a.Equal([]string{"fvTenant.json"}, names)
+		return names
+	})
+	a.NoError(err)
+	a.NoError(w.Close())
+	a.Equal(map[string]string{"fvTenant.json": `{"imdata":[]}`}, readZip(t, path))
+}
@@ -1,9 +1,16 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"math/rand/v2"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"collector/pkg/aci"
@@ -11,8 +18,6 @@ import (
 	"collector/pkg/config"
 	"collector/pkg/req"
 
-	"golang.org/x/sync/errgroup"
-
 	"collector/pkg/log"
 
 	"github.com/tidwall/gjson"
@@ -27,149 +32,478 @@ func getLogger(cfg config.FabricConfig) log.Logger {
 }
 
 // GetClient creates an ACI host client
-func GetClient(cfg config.FabricConfig) (aci.Client, error) {
+func GetClient(ctx context.Context, cfg config.FabricConfig) (aci.Client, error) {
 	// Sanatize username against quotes
 	cfg.Password = strings.ReplaceAll(cfg.Password, "\"", "\\\"")
-	client, err := aci.NewClient(
-		cfg.URL, cfg.Username, cfg.Password,
-		aci.RequestTimeout(600),
-	)
-	if err != nil {
-		return aci.Client{}, fmt.Errorf("failed to create ACI client: %v", err)
+	mods := []func(*aci.Client){aci.RequestTimeout(600)}
+
+	// Sign requests with the private key instead of logging in
+	if cfg.UsesCertAuth() {
+		data, err := os.ReadFile(cfg.PrivateKey)
+		if err != nil {
+			return aci.Client{}, fmt.Errorf("cannot read private key: %v", err)
+		}
+		key, err := aci.ParsePrivateKey(data)
+		if err != nil {
+			return aci.Client{}, fmt.Errorf("invalid private key %s: %v", cfg.PrivateKey, err)
+		}
+		mods = append(mods, aci.CertAuth(cfg.CertName, key))
 	}
 
 	// Get logger with fabric context
 	logger := getLogger(cfg)
+	mods = append(mods, aci.Logger(logger))
+
+	tlsOpts, err := getTLSOptions(cfg, logger)
+	if err != nil {
+		return aci.Client{}, err
+	}
+	mods = append(mods, aci.TLS(tlsOpts))
+
+	// Additional APICs of the cluster are failover targets
+	urls := cfg.GetURLs()
+	if len(urls) == 0 {
+		return aci.Client{}, fmt.Errorf("no APIC url configured")
+	}
+	mods = append(mods, aci.Failover(urls[1:]...))
+
+	client, err := aci.NewClient(urls[0], cfg.Username, cfg.Password, mods...)
+	if err != nil {
+		return aci.Client{}, fmt.Errorf("failed to create ACI client: %v", err)
+	}
 
 	// Authenticate
-	logger.Info().Str("host", cfg.URL).Msg("APIC host")
+	logger.Info().Strs("hosts", urls).Msg("APIC host")
 	logger.Info().Str("user", cfg.Username).Msg("APIC username")
-	logger.Info().Msg("Authenticating to the APIC...")
-	if err := client.Login(); err != nil {
-		return aci.Client{}, fmt.Errorf("cannot authenticate to the APIC at %s: %v", cfg.URL, err)
+	if client.UsesCertAuth() {
+		logger.Info().Str("cert", cfg.CertName).Msg("Using certificate-based authentication")
+	} else {
+		logger.Info().Msg("Authenticating to the APIC...")
+		if err := client.Login(ctx); err != nil {
+			return aci.Client{}, fmt.Errorf("cannot authenticate to the APIC at %s: %w", client.Host(), err)
+		}
+	}
+
+	// Add the remaining cluster members as failover targets
+	if cfg.GetDiscoverCluster() {
+		added, err := client.DiscoverCluster(ctx)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Cannot discover APIC cluster members")
+		} else if len(added) > 0 {
+			logger.Info().Strs("hosts", added).Msg("Discovered APIC cluster members")
+		}
 	}
 	return client, nil
 }
 
-func fetchWithRetry(
-	client aci.Client,
-	path string,
-	cfg config.FabricConfig,
-	mods []func(*aci.Req),
-) (gjson.Result, error) {
-	res, err := client.Get(path, mods...)
-	if err != nil && err.Error() == "result dataset is too big" {
-		return res, err
+// GetFirmware returns the firmware version running on the APICs.
+func GetFirmware(ctx context.Context, client aci.Client) (string, error) {
+	res, err := client.Get(ctx, "/api/class/firmwareCtrlrRunning", aci.Query("page-size", "1"))
+	if err != nil {
+		return "", err
 	}
-
-	// Get logger with fabric context
-	logger := getLogger(cfg)
-
-	// Retry for requestRetryCount times
-	for retries := 0; err != nil && retries < cfg.GetRequestRetryCount(); retries++ {
-		logger.Warn().Err(err).Msgf("request failed for %s. Retrying after %d seconds.",
-			path, cfg.GetRetryDelay())
-		time.Sleep(time.Second * time.Duration(cfg.GetRetryDelay()))
-		res, err = client.Get(path, mods...)
+	version := res.Get("imdata.0.firmwareCtrlrRunning.attributes.version").Str
+	if version == "" {
+		return "", errors.New("no firmware version in firmwareCtrlrRunning")
 	}
+	return version, nil
+}
+
+// getTLSOptions builds the APIC certificate verification settings for a fabric.
+func getTLSOptions(cfg config.FabricConfig, logger log.Logger) (aci.TLSOptions, error) {
+	minVersion, err := aci.ParseTLSVersion(cfg.TLSMinVersion)
 	if err != nil {
-		return res, fmt.Errorf("request failed for %s: %v", path, err)
+		return aci.TLSOptions{}, err
+	}
+	opts := aci.TLSOptions{
+		Verify:          cfg.GetTLSVerify(),
+		Fingerprint:     cfg.TLSFingerprint,
+		MinVersion:      minVersion,
+		TrustOnFirstUse: cfg.GetTLSTrustOnFirstUse(),
+	}
+	if cfg.CABundle != "" {
+		opts.RootCAs, err = aci.LoadCABundle(cfg.CABundle)
+		if err != nil {
+			return aci.TLSOptions{}, fmt.Errorf("cannot load CA bundle: %v", err)
+		}
 	}
-	return res, nil
+	if !opts.Verify {
+		logger.Debug().Msg("APIC certificate verification is disabled")
+	}
+	// The fingerprint is recorded in the config file at the end of the run
+	opts.OnFirstUse = func(fingerprint string) {
+		logger.Warn().Str("fingerprint", fingerprint).Msg("Trusting APIC certificate on first use")
+		if cfg.ConfigFile == "" {
+			logger.Info().Msgf("Pin this certificate with --tls-fingerprint %s", fingerprint)
+		}
+	}
+	return opts, nil
 }
 
-// Fetch fetches data via API and writes it to the provided archive.
-func Fetch(client aci.Client, req req.Request, arc archive.Writer, cfg config.FabricConfig) error {
-	path := "/api/class/" + req.Class
-	startTime := time.Now()
+// retryable reports whether a failed request is worth retrying.
+// Authentication failures, unknown classes and oversized datasets fail the same way
+// every time; throttling, server and transport errors are usually transient.
+func retryable(ctx context.Context, err error) bool {
+	switch {
+	case ctx.Err() != nil:
+		return false
+	case errors.Is(err, aci.ErrThrottled):
+		return true
+	case errors.Is(err, aci.ErrUnauthorized),
+		errors.Is(err, aci.ErrClassNotFound),
+		errors.Is(err, aci.ErrDatasetTooBig):
+		return false
+	}
+	return true
+}
 
-	// Get logger with fabric context
-	logger := getLogger(cfg)
+// retryPolicy is how a request is retried, from the fabric settings and the request's overrides.
+type retryPolicy struct {
+	retries  int
+	timeout  time.Duration
+	delay    time.Duration
+	maxDelay time.Duration
+}
 
-	logger.Debug().Time("start_time", startTime).Msgf("begin: %s", req.Class)
-	logger.Debug().Msgf("fetching %s...", req.Class)
+// getRetryPolicy returns the retry policy of a request.
+func getRetryPolicy(cfg config.FabricConfig, req req.Request) retryPolicy {
+	policy := retryPolicy{
+		retries:  cfg.GetRequestRetryCount(),
+		timeout:  req.Timeout,
+		delay:    time.Duration(cfg.GetRetryDelay()) * time.Second,
+		maxDelay: time.Duration(cfg.GetMaxRetryDelay()) * time.Second,
+	}
+	if req.RetryCount != 0 {
+		policy.retries = max(req.RetryCount, 0)
+	}
+	if req.RetryDelay > 0 {
+		policy.delay = req.RetryDelay
+	}
+	if req.MaxRetryDelay > 0 {
+		policy.maxDelay = req.MaxRetryDelay
+	}
+	return policy
+}
 
-	mods := []func(*aci.Req){}
-	for k, v := range req.Query {
-		mods = append(mods, aci.Query(k, v))
+// backoff returns the delay before the given retry, counting from 0.
+// The delay doubles with each retry up to maxDelay, and its upper half is random
+// so that requests and fabrics failing together don't retry in lockstep.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	delay := p.delay
+	for range retry {
+		if delay >= p.maxDelay {
+			break
+		}
+		delay *= 2
 	}
+	// rand.N panics on a negative delay
+	delay = max(min(delay, p.maxDelay), 0)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryAfter returns the delay before the given retry, honoring a Retry-After
+// wait requested by the APIC.
+func (p retryPolicy) retryAfter(retry int, err error) time.Duration {
+	delay := p.backoff(retry)
+	var apiErr *aci.APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+	return delay
+}
 
-	// Handle tenants individually for scale purposes
-	res, err := fetchWithRetry(client, path, cfg, mods)
-	if err != nil && err.Error() == "result dataset is too big" {
-		if err := paginate(client, req, arc, cfg, mods); err != nil {
-			return err
+// fetchWithRetry makes a request of the class, retrying failures as the retry policy allows.
+// The APIC that answered is recorded as serving the class.
+func (f *classFetch) fetchWithRetry(mods []func(*aci.Req)) (gjson.Result, string, error) {
+	ctx, path, throttle := f.ctx, f.path(), f.pool.throttle
+	var host string
+	mods = append(mods[:len(mods):len(mods)], aci.ServedBy(&host))
+	get := func() (gjson.Result, error) {
+		if err := throttle.acquire(ctx); err != nil {
+			return gjson.Result{}, err
 		}
+		attemptCtx := ctx
+		if f.policy.timeout > 0 {
+			var cancel context.CancelFunc
+			attemptCtx, cancel = context.WithTimeout(ctx, f.policy.timeout)
+			defer cancel()
+		}
+		start := time.Now()
+		res, err := f.client.Get(attemptCtx, path, mods...)
+		throttle.release(ctx, time.Since(start), err)
+		return res, err
 	}
 
-	logger.Info().Msgf("%s complete", req.Class)
-	err = arc.Add(req.Class+".json", []byte(res.Raw))
+	res, err := get()
+	for retry := 0; err != nil && retryable(ctx, err) && retry < f.policy.retries; retry++ {
+		delay := f.policy.retryAfter(retry, err)
+		f.logger.Warn().Err(err).Msgf("request failed for %s. Retrying after %s.",
+			path, delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return res, "", fmt.Errorf("request canceled for %s: %w", path, ctx.Err())
+		case <-time.After(delay):
+		}
+		res, err = get()
+	}
+	if errors.Is(err, aci.ErrDatasetTooBig) {
+		return res, "", err
+	}
 	if err != nil {
-		return err
+		return res, "", fmt.Errorf("request failed for %s: %w", path, err)
+	}
+	f.served(host)
+	return res, host, nil
+}
+
+// served records an APIC that answered a request of the class.
+func (f *classFetch) served(host string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !slices.Contains(f.hosts, host) {
+		f.hosts = append(f.hosts, host)
 	}
-	logger.Debug().
-		TimeDiff("elapsed_time", time.Now(), startTime).
-		Msgf("done: %s", req.Class)
-	return nil
 }
 
-func paginate(
+// ErrNotStarted is returned for requests dropped because the collection stopped before they started.
+var ErrNotStarted = errors.New("not started")
+
+// Result is the outcome of a request.
+type Result struct {
+	Class string
+	// Objects is the number of objects received.
+	Objects int
+	// Pages is the number of pages of a paginated class, 0 if it was fetched in one request.
+	Pages int
+	// Bytes is the size of the responses written to the archive.
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// Fetch fetches data via API and writes it to the provided archive.
+// Pages of large classes are fetched in parallel as configured for the fabric.
+// Canceling ctx aborts the in-flight request and any pending retries.
+func Fetch(ctx context.Context, client aci.Client, req req.Request, arc archive.Writer, cfg config.FabricConfig) error {
+	pool := NewPool(ctx, NewThrottle(cfg))
+	var err error
+	Schedule(ctx, pool, 0, client, req, arc, cfg, func(res Result) { err = res.Err })
+	pool.Wait()
+	return err
+}
+
+// Schedule queues a request on the pool and calls done once the class is written to the archive.
+// Each page of a large class is queued as a separate task of the same rank, so a slow
+// page only occupies one worker. If the pool stops before the request starts,
+// done receives an error wrapping ErrNotStarted.
+func Schedule(
+	ctx context.Context,
+	pool *Pool,
+	rank int,
 	client aci.Client,
 	req req.Request,
 	arc archive.Writer,
 	cfg config.FabricConfig,
-	mods []func(*aci.Req),
-) error {
-	path := "/api/class/" + req.Class
+	done func(Result),
+) {
+	f := &classFetch{
+		ctx:    ctx,
+		pool:   pool,
+		rank:   rank,
+		client: client,
+		req:    req,
+		arc:    arc,
+		cfg:    cfg,
+		done:   done,
+		logger: getLogger(cfg),
+		policy: getRetryPolicy(cfg, req),
+	}
+	for k, v := range req.Query {
+		f.mods = append(f.mods, aci.Query(k, v))
+	}
+	pool.Go(rank, f.run, func(cause error) {
+		done(Result{Class: req.Class, Err: fmt.Errorf("%s %w: %w", req.Class, ErrNotStarted, cause)})
+	})
+}
 
-	// Get logger with fabric context
-	logger := getLogger(cfg)
+// pageIndex records how a paginated class was split across archive files.
+// It is written as <class>.pages.json next to <class>-0.json ... <class>-N.json.
+type pageIndex struct {
+	Class      string `json:"class"`
+	TotalCount int    `json:"totalCount"`
+	PageSize   int    `json:"pageSize"`
+	Pages      int    `json:"pages"`
+}
+
+// classFetch is the state of a request queued by Schedule.
+type classFetch struct {
+	ctx    context.Context
+	pool   *Pool
+	rank   int
+	client aci.Client
+	req    req.Request
+	arc    archive.Writer
+	cfg    config.FabricConfig
+	done   func(Result)
+	logger log.Logger
+	policy retryPolicy
+	mods   []func(*aci.Req)
+	start  time.Time
+	bytes  atomic.Int64
+	// hosts are the APICs that served the class, guarded by mu
+	hosts []string
+
+	// Pagination state
+	pagination aci.Pagination
+	pages      int
+	totalCount int
+	received   atomic.Int64
+	mu         sync.Mutex
+	remaining  int
+	errs       []error
+}
+
+func (f *classFetch) path() string {
+	return "/api/class/" + f.req.Class
+}
 
-	logger.Info().Msgf("fetching large dataset for %s...", req.Class)
-	mods = append(mods, aci.Query("page-size", strconv.Itoa(cfg.GetPageSize())))
+func (f *classFetch) run() {
+	f.start = time.Now()
+	f.logger.Debug().Time("start_time", f.start).Msgf("begin: %s", f.req.Class)
+	f.logger.Debug().Msgf("fetching %s...", f.req.Class)
 
-	logger.Info().Msgf("fetching page 0 for %s...", req.Class)
-	res, err := fetchWithRetry(client, path, cfg, mods)
+	// Classes known to be large from preflight are paged right away
+	if f.req.PageSize > 0 {
+		f.paginate(f.req.PageSize)
+		return
+	}
+	res, _, err := f.fetchWithRetry(f.mods)
+	if errors.Is(err, aci.ErrDatasetTooBig) {
+		f.paginate(f.cfg.GetPageSize())
+		return
+	}
+	if err == nil {
+		f.received.Add(int64(len(res.Get("imdata").Array())))
+		f.bytes.Add(int64(len(res.Raw)))
+		err = f.arc.Add(f.req.Class+".json", []byte(res.Raw))
+	}
+	f.finish(err)
+}
+
+func (f *classFetch) finish(err error) {
+	if err == nil {
+		f.mu.Lock()
+		hosts := strings.Join(f.hosts, ", ")
+		f.mu.Unlock()
+		f.logger.Info().Str("apic", hosts).Msgf("%s complete", f.req.Class)
+		f.logger.Debug().
+			TimeDiff("elapsed_time", time.Now(), f.start).
+			Msgf("done: %s", f.req.Class)
+	}
+	f.done(Result{
+		Class:    f.req.Class,
+		Objects:  int(f.received.Load()),
+		Pages:    f.pages,
+		Bytes:    f.bytes.Load(),
+		Duration: time.Since(f.start),
+		Err:      err,
+	})
+}
+
+// paginate fetches the first page and queues the remaining pages, ordered as by aci.GetPages.
+// Pages replace <class>.json in the archive.
+func (f *classFetch) paginate(pageSize int) {
+	f.logger.Info().Msgf("fetching large dataset for %s...", f.req.Class)
+	var err error
+	f.pagination, err = aci.NewPagination(f.path(), pageSize, f.mods...)
 	if err != nil {
-		return err
-	}
-
-	cnt, _ := strconv.Atoi(res.Get("totalCount").Str)
-
-	logger.Info().Msgf("Total record count for %s: %d", req.Class, cnt)
-	pages := cnt / cfg.GetPageSize()
-
-	batch := 1
-	for i := 0; i < pages; i += cfg.GetBatchSize() {
-		var g errgroup.Group
-		logger.Info().Msg(strings.Repeat("*", 30))
-		logger.Info().Msgf("Fetching paginated request batch %d", batch)
-		logger.Info().Msg(strings.Repeat("*", 30))
-		for j := i; j < i+cfg.GetBatchSize() && j < pages; j++ {
-			page := j
-			g.Go(func() error {
-				// Get logger with fabric context for goroutine
-				pageLogger := getLogger(cfg)
-
-				pageLogger.Info().Msgf("fetching page %d of %d for %s...", page, pages, req.Class)
-				mods := append(mods, aci.Query("page", strconv.Itoa(page)))
-				res, err := fetchWithRetry(client, path, cfg, mods)
-				if err != nil {
-					return fmt.Errorf("failed to fetch large dataset for %s", req.Class)
-				}
-				pageLogger.Info().Msgf("%d of %d for %s complete", page, pages, req.Class)
-				err = arc.Add(fmt.Sprintf("%s-%d.json", req.Class, page), []byte(res.Raw))
-				if err != nil {
-					return fmt.Errorf("failed to write large dataset for %s", req.Class)
-				}
-				return nil
-			})
-		}
-		err = g.Wait()
-		if err != nil {
-			logger.Error().Err(err).Msg("Error fetching data.")
-		}
-		batch++
+		f.finish(fmt.Errorf("cannot paginate %s: %w", f.req.Class, err))
+		return
+	}
+
+	f.logger.Info().Msgf("fetching page 0 for %s...", f.req.Class)
+	res, _, err := f.fetchPage(0)
+	if err != nil {
+		f.finish(err)
+		return
+	}
+	f.totalCount, f.pages, err = f.pagination.Pages(res)
+	if err != nil {
+		f.finish(fmt.Errorf("invalid first page of %s: %w", f.req.Class, err))
+		return
+	}
+	f.logger.Info().Msgf("Total record count for %s: %d in %d pages", f.req.Class, f.totalCount, f.pages)
+	if f.pages == 1 {
+		f.finishPages()
+		return
+	}
+
+	f.remaining = f.pages - 1
+	for page := 1; page < f.pages; page++ {
+		f.pool.Go(f.rank, func() {
+			f.logger.Info().Msgf("fetching page %d of %d for %s...", page, f.pages, f.req.Class)
+			_, host, err := f.fetchPage(page)
+			if err == nil {
+				f.logger.Info().Str("apic", host).
+					Msgf("%d of %d for %s complete", page, f.pages, f.req.Class)
+			}
+			f.pageDone(err)
+		}, func(cause error) {
+			f.pageDone(fmt.Errorf("page %d of %s not fetched: %w", page, f.req.Class, cause))
+		})
+	}
+}
+
+func (f *classFetch) fetchPage(page int) (gjson.Result, string, error) {
+	res, host, err := f.fetchWithRetry(f.pagination.Page(page))
+	if err != nil {
+		return res, host, err
+	}
+	n, err := f.pagination.Objects(res)
+	if err != nil {
+		return res, host, fmt.Errorf("invalid response for page %d of %s: %w", page, f.req.Class, err)
+	}
+	f.received.Add(int64(n))
+	f.bytes.Add(int64(len(res.Raw)))
+	if err := f.arc.Add(fmt.Sprintf("%s-%d.json", f.req.Class, page), []byte(res.Raw)); err != nil {
+		return res, host, fmt.Errorf("failed to write page %d of %s: %w", page, f.req.Class, err)
+	}
+	return res, host, nil
+}
+
+// pageDone records the result of a page; the last page to finish completes the class.
+func (f *classFetch) pageDone(err error) {
+	f.mu.Lock()
+	if err != nil {
+		f.logger.Error().Err(err).Msg("Error fetching data.")
+		f.errs = append(f.errs, err)
+	}
+	f.remaining--
+	last := f.remaining == 0
+	f.mu.Unlock()
+	if last {
+		f.finishPages()
+	}
+}
+
+// finishPages verifies the number of objects received against totalCount and writes the page index.
+func (f *classFetch) finishPages() {
+	if len(f.errs) > 0 {
+		f.finish(errors.Join(f.errs...))
+		return
+	}
+	if err := f.pagination.Verify(int(f.received.Load()), f.totalCount); err != nil {
+		f.finish(err)
+		return
+	}
+	index, err := json.MarshalIndent(pageIndex{
+		Class:      f.req.Class,
+		TotalCount: f.totalCount,
+		PageSize:   f.pagination.PageSize(),
+		Pages:      f.pages,
+	}, "", "  ")
+	if err == nil {
+		err = f.arc.Add(f.req.Class+".pages.json", index)
 	}
-	return nil
+	f.finish(err)
 }
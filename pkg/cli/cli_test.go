@@ -1,6 +1,10 @@
 package cli
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +17,9 @@ import (
 	"gopkg.in/h2non/gock.v1"
 )
 
+// mockArchiveMu guards mockArchiveWriter.files against concurrent page writes.
+var mockArchiveMu sync.Mutex
+
 type mockArchiveWriter struct {
 	files map[string][]byte
 }
@@ -22,6 +29,8 @@ func (a mockArchiveWriter) Close() error {
 }
 
 func (a mockArchiveWriter) Add(name string, content []byte) error {
+	mockArchiveMu.Lock()
+	defer mockArchiveMu.Unlock()
 	a.files[name] = content
 	return nil
 }
@@ -41,7 +50,6 @@ func TestFetch(t *testing.T) {
 
 	// Test client
 	client, _ := aci.NewClient("apic", "usr", "pwd")
-	client.LastRefresh = time.Now()
 	gock.InterceptClient(client.HTTPClient)
 
 	// Test request
@@ -55,7 +63,7 @@ func TestFetch(t *testing.T) {
 	}
 
 	// Write zip
-	err := Fetch(client, req, arc, config.FabricConfig{})
+	err := Fetch(context.Background(), client, req, arc, config.FabricConfig{})
 	a.NoError(err)
 
 	// Verify content written to mock archive
@@ -65,3 +73,373 @@ func TestFetch(t *testing.T) {
 	a.Equal("uni/my-zero", classes.Get("0.dn").Str)
 	a.Equal("uni/my-one", classes.Get("1.dn").Str)
 }
+
+func TestFetchCanceled(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	gock.New("https://apic").
+		Get("/api/class/myClass.json").
+		Reply(500)
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+
+	// Canceling during the retry delay stops retrying
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	retryDelay := 60
+	cfg := config.FabricConfig{RetryDelay: &retryDelay}
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	start := time.Now()
+	err := Fetch(ctx, client, req.Request{Class: "myClass"}, arc, cfg)
+	a.ErrorIs(err, context.DeadlineExceeded)
+	a.Less(time.Since(start), 5*time.Second)
+	a.Empty(arc.files)
+}
+
+func TestFetchRetry(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	retryDelay := 0
+	cfg := config.FabricConfig{RetryDelay: &retryDelay}
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	// Unknown classes are not retried
+	gock.New("https://apic").
+		Get("/api/class/fooBar.json").
+		Times(1).
+		Reply(400).
+		BodyString(aci.Body{}.
+			Set("imdata.0.error.attributes.code", "122").
+			Set("imdata.0.error.attributes.text", "unknown managed object class fooBar").
+			Str)
+	err := Fetch(context.Background(), client, req.Request{Class: "fooBar"}, arc, cfg)
+	a.ErrorIs(err, aci.ErrClassNotFound)
+	a.True(gock.IsDone())
+
+	// Throttling is retried
+	gock.New("https://apic").Get("/api/class/myClass.json").Times(2).Reply(429)
+	gock.New("https://apic").Get("/api/class/myClass.json").Reply(200)
+	err = Fetch(context.Background(), client, req.Request{Class: "myClass"}, arc, cfg)
+	a.NoError(err)
+	a.True(gock.IsDone())
+}
+
+// mockTooBig mocks the APIC rejecting a class query as too big.
+func mockTooBig(class string) {
+	gock.New("https://apic").
+		Get("/api/class/" + class + ".json").
+		Times(1).
+		Reply(400).
+		BodyString(aci.Body{}.
+			Set("imdata.0.error.attributes.code", "400").
+			Set("imdata.0.error.attributes.text", "Unable to process the query, result dataset is too big").
+			Str)
+}
+
+// mockPages mocks the pages of a class with pageSize objects out of total.
+func mockPages(class string, total, pageSize, pages int) {
+	for page := 0; page < pages; page++ {
+		body := aci.Body{}.Set("totalCount", strconv.Itoa(total))
+		body = body.SetRaw("imdata", "[]")
+		for i := page * pageSize; i < (page+1)*pageSize && i < total; i++ {
+			body = body.Set(fmt.Sprintf("imdata.%d.%s.attributes.dn", i-page*pageSize, class),
+				fmt.Sprintf("uni/obj-%d", i))
+		}
+		gock.New("https://apic").
+			Get("/api/class/"+class+".json").
+			MatchParam("page", strconv.Itoa(page)).
+			MatchParam("page-size", strconv.Itoa(pageSize)).
+			MatchParam("order-by", class+`.dn\|asc`).
+			Reply(200).
+			BodyString(body.Str)
+	}
+}
+
+func TestFetchPaginated(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	pageSize := 2
+	cfg := config.FabricConfig{PageSize: &pageSize}
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	// The last partial page is fetched and the empty unpaginated result is not written
+	mockTooBig("myClass")
+	mockPages("myClass", 5, 2, 3)
+	err := Fetch(context.Background(), client, req.Request{Class: "myClass"}, arc, cfg)
+	a.NoError(err)
+	a.True(gock.IsDone())
+	a.NotContains(arc.files, "myClass.json")
+	a.Equal("uni/obj-4", gjson.GetBytes(arc.files["myClass-2.json"], "imdata.0.myClass.attributes.dn").Str)
+
+	index := gjson.ParseBytes(arc.files["myClass.pages.json"])
+	a.Equal(int64(3), index.Get("pages").Int())
+	a.Equal(int64(5), index.Get("totalCount").Int())
+	a.Equal(int64(2), index.Get("pageSize").Int())
+}
+
+func TestFetchPaginatedOrderBy(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	pageSize := 2
+	cfg := config.FabricConfig{PageSize: &pageSize}
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	// The order of the request is kept
+	mockTooBig("myClass")
+	gock.New("https://apic").
+		Get("/api/class/myClass.json").
+		MatchParam("page", "0").
+		MatchParam("order-by", `myClass.name\|desc`).
+		Reply(200).
+		BodyString(`{"totalCount":"1","imdata":[{"myClass":{"attributes":{"dn":"uni/obj-0"}}}]}`)
+	r := req.Request{Class: "myClass", Query: map[string]string{"order-by": "myClass.name|desc"}}
+	a.NoError(Fetch(context.Background(), client, r, arc, cfg))
+	a.True(gock.IsDone())
+	a.Contains(arc.files, "myClass-0.json")
+}
+
+func TestFetchPaginatedCountMismatch(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	pageSize := 2
+	cfg := config.FabricConfig{PageSize: &pageSize}
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	// totalCount claims more objects than the pages contain
+	mockTooBig("myClass")
+	mockPages("myClass", 4, 2, 1)
+	gock.New("https://apic").
+		Get("/api/class/myClass.json").
+		MatchParam("page", "1").
+		Reply(200).
+		BodyString(`{"totalCount":"4","imdata":[{"myClass":{"attributes":{"dn":"uni/obj-2"}}}]}`)
+	err := Fetch(context.Background(), client, req.Request{Class: "myClass"}, arc, cfg)
+	a.ErrorIs(err, aci.ErrCountMismatch)
+	a.NotContains(arc.files, "myClass.pages.json")
+}
+
+func TestFetchPreflightPaged(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	// Classes planned for paging skip the full query
+	mockPages("myClass", 3, 2, 2)
+	err := Fetch(context.Background(), client, req.Request{Class: "myClass", PageSize: 2}, arc, config.FabricConfig{})
+	a.NoError(err)
+	a.True(gock.IsDone())
+	a.Contains(arc.files, "myClass-1.json")
+	a.Equal(int64(2), gjson.GetBytes(arc.files["myClass.pages.json"], "pageSize").Int())
+}
+
+func TestPreflight(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	pageSize := 1000
+	cfg := config.FabricConfig{PageSize: &pageSize}
+
+	mockCount := func(class string, count int) {
+		gock.New("https://apic").
+			Get("/api/class/"+class+".json").
+			MatchParam("rsp-subtree-include", "count").
+			Reply(200).
+			BodyString(fmt.Sprintf(`{"totalCount":"1","imdata":[{"moCount":{"attributes":{"count":"%d"}}}]}`, count))
+	}
+	mockCount("small", 10)
+	mockCount("large", 2500)
+	gock.New("https://apic").
+		Get("/api/class/unknown.json").
+		Reply(400).
+		BodyString(`{"imdata":[{"error":{"attributes":{"code":"122","text":"unknown managed object class unknown"}}}]}`)
+
+	reqs := []req.Request{{Class: "small"}, {Class: "large"}, {Class: "unknown"}}
+	planned := Preflight(context.Background(), NewThrottle(cfg), client, reqs, cfg)
+	a.True(gock.IsDone())
+	a.Equal(0, planned[0].PageSize)
+	a.Equal(1000, planned[1].PageSize)
+	a.Equal(0, planned[2].PageSize)
+	a.Equal(0, reqs[1].PageSize, "input requests are not modified")
+}
+
+func TestPreflightCountQuery(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	pageSize := 1000
+	cfg := config.FabricConfig{PageSize: &pageSize}
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	// Requests that are count queries themselves are not counted nor paged
+	gock.New("https://apic").
+		Get("/api/class/fvCEp.json").
+		MatchParam("rsp-subtree-include", "count").
+		Reply(200).
+		BodyString(`{"totalCount":"1","imdata":[{"moCount":{"attributes":{"count":"5000"}}}]}`)
+	reqs := []req.Request{{Class: "fvCEp", Query: map[string]string{"rsp-subtree-include": "count"}}}
+	planned := Preflight(context.Background(), NewThrottle(cfg), client, reqs, cfg)
+	a.Equal(0, planned[0].PageSize)
+	a.Equal(0, planned[0].Count)
+	a.False(gock.IsDone(), "no count query is made")
+
+	a.NoError(Fetch(context.Background(), client, planned[0], arc, cfg))
+	a.True(gock.IsDone())
+	a.Contains(arc.files, "fvCEp.json")
+	a.NotContains(arc.files, "fvCEp-0.json")
+	a.NotContains(arc.files, "fvCEp.pages.json")
+}
+
+func TestRetryPolicy(t *testing.T) {
+	a := assert.New(t)
+	retryCount, retryDelay, maxRetryDelay := 3, 10, 60
+	cfg := config.FabricConfig{
+		RequestRetryCount: &retryCount,
+		RetryDelay:        &retryDelay,
+		MaxRetryDelay:     &maxRetryDelay,
+	}
+
+	// Delays double up to the max, with the upper half randomized
+	policy := getRetryPolicy(cfg, req.Request{Class: "myClass"})
+	for retry, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		delay := policy.backoff(retry)
+		a.GreaterOrEqual(delay, want/2)
+		a.LessOrEqual(delay, want)
+	}
+
+	// Retry-After takes precedence over a shorter backoff
+	throttled := &aci.APIError{StatusCode: 429, RetryAfter: 5 * time.Minute}
+	a.Equal(5*time.Minute, policy.retryAfter(0, throttled))
+
+	// Requests override the fabric settings
+	policy = getRetryPolicy(cfg, req.Request{
+		Class:      "myClass",
+		RetryCount: -1,
+		Timeout:    time.Minute,
+		RetryDelay: time.Second,
+	})
+	a.Equal(0, policy.retries)
+	a.Equal(time.Minute, policy.timeout)
+	a.Equal(time.Second, policy.delay)
+	a.Equal(time.Minute, policy.maxDelay)
+
+	// A negative delay doesn't panic
+	retryDelay = -1
+	policy = getRetryPolicy(cfg, req.Request{Class: "myClass"})
+	a.Equal(time.Duration(0), policy.backoff(1))
+}
+
+func TestFetchTimeout(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+
+	// A slow attempt times out and is retried
+	gock.New("https://apic").
+		Get("/api/class/myClass.json").
+		Reply(200).
+		Delay(time.Second).
+		BodyString(`{"totalCount":"0","imdata":[]}`)
+	gock.New("https://apic").
+		Get("/api/class/myClass.json").
+		Reply(200).
+		BodyString(`{"totalCount":"0","imdata":[]}`)
+	request := req.Request{
+		Class:      "myClass",
+		Timeout:    50 * time.Millisecond,
+		RetryDelay: time.Millisecond,
+	}
+	start := time.Now()
+	err := Fetch(context.Background(), client, request, arc, config.FabricConfig{})
+	a.NoError(err)
+	a.Less(time.Since(start), 500*time.Millisecond)
+	a.True(gock.IsDone())
+}
+
+func TestScheduleResult(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+	arc := mockArchiveWriter{files: make(map[string][]byte)}
+	cfg := config.FabricConfig{}
+	pool := NewPool(context.Background(), NewThrottle(cfg))
+
+	// Results count the objects, pages and bytes written to the archive
+	mockPages("myClass", 3, 2, 2)
+	gock.New("https://apic").
+		Get("/api/class/otherClass.json").
+		Reply(200).
+		BodyString(`{"totalCount":"1","imdata":[{"otherClass":{"attributes":{"dn":"uni/x"}}}]}`)
+	results := make(map[string]Result)
+	var mu sync.Mutex
+	done := func(res Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[res.Class] = res
+	}
+	Schedule(context.Background(), pool, 0, client, req.Request{Class: "myClass", PageSize: 2}, arc, cfg, done)
+	Schedule(context.Background(), pool, 1, client, req.Request{Class: "otherClass"}, arc, cfg, done)
+	pool.Wait()
+
+	paged := results["myClass"]
+	a.NoError(paged.Err)
+	a.Equal(3, paged.Objects)
+	a.Equal(2, paged.Pages)
+	a.Equal(int64(len(arc.files["myClass-0.json"])+len(arc.files["myClass-1.json"])), paged.Bytes)
+	a.Positive(paged.Duration)
+
+	single := results["otherClass"]
+	a.NoError(single.Err)
+	a.Equal(1, single.Objects)
+	a.Equal(0, single.Pages)
+	a.Equal(int64(len(arc.files["otherClass.json"])), single.Bytes)
+}
+
+func TestGetFirmware(t *testing.T) {
+	a := assert.New(t)
+	defer gock.Off()
+
+	client, _ := aci.NewClient("apic", "usr", "pwd")
+	gock.InterceptClient(client.HTTPClient)
+
+	gock.New("https://apic").
+		Get("/api/class/firmwareCtrlrRunning.json").
+		Reply(200).
+		BodyString(`{"totalCount":"3","imdata":[{"firmwareCtrlrRunning":{"attributes":{"version":"5.2(7f)"}}}]}`)
+	version, err := GetFirmware(context.Background(), client)
+	a.NoError(err)
+	a.Equal("5.2(7f)", version)
+
+	gock.New("https://apic").
+		Get("/api/class/firmwareCtrlrRunning.json").
+		Reply(200).
+		BodyString(`{"totalCount":"0","imdata":[]}`)
+	_, err = GetFirmware(context.Background(), client)
+	a.Error(err)
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Pool runs tasks on a bounded number of workers.
+// The requests made by tasks are further limited by the pool's Throttle.
+// Queued tasks run lowest rank first and in submission order within a rank.
+// Once the stop context is done, queued tasks are dropped instead of run;
+// running tasks are not interrupted.
+type Pool struct {
+	stop     context.Context
+	throttle *Throttle
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    taskQueue
+	seq      int
+	active   int
+	closed   bool
+	// unwatch stops waking workers on stop
+	unwatch func() bool
+}
+
+type task struct {
+	rank    int
+	seq     int
+	run     func()
+	dropped func(cause error)
+}
+
+// taskQueue implements heap.Interface ordered by rank, then submission.
+type taskQueue []*task
+
+func (q taskQueue) Len() int { return len(q) }
+
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].rank != q[j].rank {
+		return q[i].rank < q[j].rank
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q taskQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *taskQueue) Push(x any) { *q = append(*q, x.(*task)) }
+
+func (q *taskQueue) Pop() any {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
+
+// NewPool starts a pool with a worker for each request the throttle may allow in flight.
+func NewPool(stop context.Context, throttle *Throttle) *Pool {
+	p := &Pool{stop: stop, throttle: throttle}
+	p.cond = sync.NewCond(&p.mu)
+	for range throttle.Max() {
+		go p.work()
+	}
+	// Wake idle workers to drop the queue
+	p.unwatch = context.AfterFunc(stop, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.cond.Broadcast()
+	})
+	return p
+}
+
+// Go queues a task.
+// dropped, if not nil, is called instead of run with the stop cause if the pool stops before the task starts.
+// Tasks may queue further tasks.
+func (p *Pool) Go(rank int, run func(), dropped func(cause error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	heap.Push(&p.queue, &task{rank: rank, seq: p.seq, run: run, dropped: dropped})
+	p.active++
+	p.cond.Broadcast()
+}
+
+// Wait waits until all tasks, including tasks queued by other tasks, have run
+// or been dropped, and shuts the pool down.
+func (p *Pool) Wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.active > 0 {
+		p.cond.Wait()
+	}
+	p.closed = true
+	p.cond.Broadcast()
+	p.unwatch()
+}
+
+func (p *Pool) work() {
+	for {
+		p.mu.Lock()
+		for p.queue.Len() == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		t := heap.Pop(&p.queue).(*task)
+		p.mu.Unlock()
+
+		if p.stop.Err() != nil {
+			if t.dropped != nil {
+				t.dropped(context.Cause(p.stop))
+			}
+		} else {
+			t.run()
+		}
+
+		p.mu.Lock()
+		p.active--
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"collector/pkg/config"
+	"collector/pkg/req"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// batchConfig returns a fabric config with the given batch size.
+func batchConfig(batchSize int) config.FabricConfig {
+	return config.FabricConfig{BatchSize: &batchSize}
+}
+
+func TestPool(t *testing.T) {
+	a := assert.New(t)
+
+	// Tasks run by rank, then in submission order; tasks can queue tasks
+	pool := NewPool(context.Background(), NewThrottle(batchConfig(1)))
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	run := func(name string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+		}
+	}
+	block := make(chan struct{})
+	pool.Go(0, func() { <-block }, nil)
+	pool.Go(2, run("c"), nil)
+	pool.Go(1, func() {
+		run("b1")()
+		pool.Go(1, run("b2"), nil)
+	}, nil)
+	pool.Go(0, run("a"), nil)
+	close(block)
+	pool.Wait()
+	a.Equal([]string{"a", "b1", "b2", "c"}, order)
+}
+
+func TestPoolLimit(t *testing.T) {
+	a := assert.New(t)
+
+	pool := NewPool(context.Background(), NewThrottle(batchConfig(3)))
+	var inFlight, maxInFlight atomic.Int32
+	for range 20 {
+		pool.Go(0, func() {
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			inFlight.Add(-1)
+		}, nil)
+	}
+	pool.Wait()
+	a.Equal(int32(3), maxInFlight.Load())
+}
+
+func TestPoolStop(t *testing.T) {
+	a := assert.New(t)
+
+	stop, cancel := context.WithCancelCause(context.Background())
+	pool := NewPool(stop, NewThrottle(batchConfig(1)))
+	errStop := context.Canceled
+	var ran, dropped atomic.Int32
+	started, block := make(chan struct{}), make(chan struct{})
+	pool.Go(0, func() {
+		close(started)
+		<-block
+		ran.Add(1)
+	}, nil)
+	for range 5 {
+		pool.Go(0, func() { ran.Add(1) }, func(cause error) {
+			a.ErrorIs(cause, errStop)
+			dropped.Add(1)
+		})
+	}
+
+	// Running tasks finish, queued tasks are dropped
+	<-started
+	cancel(errStop)
+	close(block)
+	pool.Wait()
+	a.Equal(int32(1), ran.Load())
+	a.Equal(int32(5), dropped.Load())
+}
+
+func TestPrioritize(t *testing.T) {
+	a := assert.New(t)
+
+	reqs := []req.Request{
+		{Class: "small", Count: 10},
+		{Class: "faultInst", Count: 20},
+		{Class: "large", Count: 5000},
+		{Class: "unknown"},
+		{Class: "topSystem", Count: 3},
+	}
+	sorted := Prioritize(reqs, []string{"topSystem", "faultInst"})
+	var classes []string
+	for _, r := range sorted {
+		classes = append(classes, r.Class)
+	}
+	a.Equal([]string{"topSystem", "faultInst", "large", "small", "unknown"}, classes)
+	a.Equal("small", reqs[0].Class, "input requests are not reordered")
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"collector/pkg/aci"
+	"collector/pkg/config"
+	"collector/pkg/req"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Preflight counts the objects of each request before collection starts.
+// Requests with more objects than the page size get PageSize set, so Fetch pages
+// them right away instead of waiting for the APIC to reject the full query as too big.
+// Requests that cannot be counted are returned unchanged and fall back to paging on demand.
+// Requests that set rsp-subtree-include themselves, e.g. count queries, are not counted,
+// as their reply is not the objects of the class.
+// The estimated object count and duration of the collection are logged.
+// Count queries are limited by the throttle like any other request.
+func Preflight(
+	ctx context.Context,
+	throttle *Throttle,
+	client aci.Client,
+	reqs []req.Request,
+	cfg config.FabricConfig,
+) []req.Request {
+	// Get logger with fabric context
+	logger := getLogger(cfg)
+	logger.Info().Msgf("Counting objects for %d requests...", len(reqs))
+
+	planned := make([]req.Request, len(reqs))
+	copy(planned, reqs)
+	counts := make([]int, len(reqs))
+
+	var (
+		mu      sync.Mutex
+		elapsed time.Duration
+		counted int
+	)
+	var g errgroup.Group
+	g.SetLimit(throttle.Max())
+	for i, r := range reqs {
+		if _, ok := r.Query["rsp-subtree-include"]; ok {
+			continue
+		}
+		g.Go(func() error {
+			mods := []func(*aci.Req){}
+			for k, v := range r.Query {
+				mods = append(mods, aci.Query(k, v))
+			}
+			if err := throttle.acquire(ctx); err != nil {
+				counts[i] = -1
+				return nil
+			}
+			start := time.Now()
+			n, err := client.Count(ctx, "/api/class/"+r.Class, mods...)
+			throttle.release(ctx, time.Since(start), err)
+			if err != nil {
+				logger.Debug().Err(err).Msgf("cannot count %s", r.Class)
+				counts[i] = -1
+				return nil
+			}
+			mu.Lock()
+			elapsed += time.Since(start)
+			counted++
+			mu.Unlock()
+
+			counts[i] = n
+			planned[i].Count = n
+			if n > cfg.GetPageSize() {
+				planned[i].PageSize = cfg.GetPageSize()
+			}
+			logger.Debug().Int("count", n).Int("page_size", planned[i].PageSize).Msgf("counted %s", r.Class)
+			return nil
+		})
+	}
+	g.Wait()
+
+	if ctx.Err() != nil || counted == 0 {
+		logger.Warn().Msg("Cannot count objects, large classes will be paged when the APIC rejects them")
+		return reqs
+	}
+
+	// Each paged class takes one request per page
+	objects, requests, unknown := 0, 0, 0
+	for i, n := range counts {
+		if n < 0 {
+			unknown++
+			requests++
+			continue
+		}
+		objects += n
+		if planned[i].PageSize > 0 {
+			requests += aci.PageCount(n, planned[i].PageSize)
+		} else {
+			requests++
+		}
+	}
+
+	// Rough estimate: count queries are answered about as fast as small queries
+	rounds := (requests + throttle.Limit() - 1) / throttle.Limit()
+	eta := time.Duration(rounds) * (elapsed / time.Duration(counted))
+	logger.Info().
+		Int("objects", objects).
+		Int("requests", requests).
+		Int("uncounted", unknown).
+		Str("eta", eta.Round(time.Second).String()).
+		Msgf("Estimated %d objects in %d requests", objects, requests)
+	return planned
+}
+
+// Prioritize orders requests for scheduling.
+// Classes listed in priority come first in the listed order, followed by the
+// remaining classes from largest to smallest preflight count.
+func Prioritize(reqs []req.Request, priority []string) []req.Request {
+	rank := func(r req.Request) int {
+		if i := slices.Index(priority, r.Class); i >= 0 {
+			return i
+		}
+		return len(priority)
+	}
+	sorted := slices.Clone(reqs)
+	slices.SortStableFunc(sorted, func(a, b req.Request) int {
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra - rb
+		}
+		return b.Count - a.Count
+	})
+	return sorted
+}
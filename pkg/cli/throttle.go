@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"collector/pkg/aci"
+	"collector/pkg/config"
+	"collector/pkg/log"
+)
+
+const (
+	// adaptiveStart is the initial number of requests in flight in adaptive mode.
+	adaptiveStart = 2
+	// latencyTolerance is how far smoothed latency may rise above its lowest
+	// value before adaptive mode considers the APIC overloaded.
+	latencyTolerance = 2.5
+	// latencySmoothing is the weight of a new sample in the latency average.
+	latencySmoothing = 0.2
+	// baselineDrift lets the lowest latency follow a lasting change in the mix
+	// of requests, e.g. from large pages to small classes.
+	baselineDrift = 0.01
+)
+
+// ConcurrencySample is a change of the number of requests allowed in flight.
+type ConcurrencySample struct {
+	Time   time.Time `json:"time"`
+	Limit  int       `json:"limit"`
+	Reason string    `json:"reason"`
+}
+
+// Throttle bounds the number of APIC requests in flight and, optionally, the request rate.
+// In adaptive mode the bound starts low and is raised by one after each round of
+// successful requests, then halved when the APIC throttles, requests time out or
+// latency rises (AIMD). Otherwise it is fixed at the batch size.
+type Throttle struct {
+	mu       sync.Mutex
+	logger   log.Logger
+	adaptive bool
+	limit    int
+	max      int
+	inFlight int
+	// wake is closed and replaced whenever a slot may have become available
+	wake chan struct{}
+
+	// Adaptive state
+	successes    int
+	latency      time.Duration
+	minLatency   time.Duration
+	lastDecrease time.Time
+	history      []ConcurrencySample
+
+	// Rate cap
+	interval time.Duration
+	next     time.Time
+
+	// budget is shared with the throttles of the other fabrics of the run
+	budget *Budget
+}
+
+// Budget bounds the number of APIC requests in flight across all fabrics of a run.
+// A nil budget does not limit requests.
+type Budget struct {
+	slots chan struct{}
+}
+
+// NewBudget returns a budget of n requests in flight, nil for no limit.
+func NewBudget(n int) *Budget {
+	if n <= 0 {
+		return nil
+	}
+	return &Budget{slots: make(chan struct{}, n)}
+}
+
+func (b *Budget) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case b.slots <- struct{}{}:
+		return nil
+	}
+}
+
+func (b *Budget) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// SharedBudget makes the throttle hold a slot of the budget for each request in flight.
+func SharedBudget(b *Budget) func(*Throttle) {
+	return func(t *Throttle) {
+		t.budget = b
+	}
+}
+
+// ThrottleLogger sets the logger of the throttle, by default one with the fabric as context.
+func ThrottleLogger(logger log.Logger) func(*Throttle) {
+	return func(t *Throttle) {
+		t.logger = logger
+	}
+}
+
+// NewThrottle returns the throttle configured for a fabric.
+func NewThrottle(cfg config.FabricConfig, mods ...func(*Throttle)) *Throttle {
+	t := &Throttle{
+		logger: getLogger(cfg),
+		limit:  max(cfg.GetBatchSize(), 1),
+		wake:   make(chan struct{}),
+	}
+	t.max = t.limit
+	if cfg.GetAdaptiveConcurrency() {
+		t.adaptive = true
+		t.max = max(cfg.GetMaxConcurrency(), 1)
+		t.limit = min(adaptiveStart, t.max)
+	}
+	if rps := cfg.GetMaxRequestsPerSecond(); rps > 0 {
+		t.interval = time.Duration(float64(time.Second) / rps)
+	}
+	for _, mod := range mods {
+		mod(t)
+	}
+	t.record("start")
+	return t
+}
+
+// Max returns the most requests the throttle will ever allow in flight.
+func (t *Throttle) Max() int {
+	return t.max
+}
+
+// Limit returns the number of requests currently allowed in flight.
+func (t *Throttle) Limit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// History returns the changes of the in-flight limit.
+func (t *Throttle) History() []ConcurrencySample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ConcurrencySample{}, t.history...)
+}
+
+// acquire waits for a request slot, with a rate cap for the request's turn, and with
+// a budget for a slot of the budget. A nil throttle does not limit requests.
+func (t *Throttle) acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		if t.inFlight < t.limit {
+			t.inFlight++
+			t.mu.Unlock()
+			break
+		}
+		wake := t.wake
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+	if t.interval > 0 {
+		// Space requests evenly at the capped rate
+		t.mu.Lock()
+		start := time.Now()
+		if t.next.After(start) {
+			start = t.next
+		}
+		t.next = start.Add(t.interval)
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			t.free()
+			return ctx.Err()
+		case <-time.After(time.Until(start)):
+		}
+	}
+
+	// Waiting for the budget last keeps its slots for requests ready to go
+	if err := t.budget.acquire(ctx); err != nil {
+		t.free()
+		return err
+	}
+	return nil
+}
+
+// free gives up a request slot acquired for a request that was never sent.
+func (t *Throttle) free() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	t.signal()
+}
+
+// release frees a request slot and adapts the limit to the outcome of the request.
+func (t *Throttle) release(ctx context.Context, latency time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	t.budget.release()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	defer t.signal()
+	if !t.adaptive || ctx.Err() != nil {
+		return
+	}
+
+	switch {
+	case errors.Is(err, aci.ErrThrottled):
+		t.decrease("throttled")
+		return
+	case isTimeout(err):
+		t.decrease("timeout")
+		return
+	case err != nil:
+		// Other failures say nothing about load
+		return
+	}
+
+	if t.latency == 0 {
+		t.latency = latency
+	} else {
+		t.latency += time.Duration(latencySmoothing * float64(latency-t.latency))
+	}
+	if t.minLatency == 0 || t.latency < t.minLatency {
+		t.minLatency = t.latency
+	} else {
+		t.minLatency += time.Duration(baselineDrift * float64(t.latency-t.minLatency))
+	}
+	if float64(t.latency) > latencyTolerance*float64(t.minLatency) {
+		t.decrease("latency")
+		return
+	}
+
+	t.successes++
+	if t.successes >= t.limit && t.limit < t.max {
+		t.successes = 0
+		t.limit++
+		t.record("increase")
+		t.logger.Debug().Int("concurrency", t.limit).Msg("Raising concurrency")
+	}
+}
+
+// decrease halves the limit, at most once per smoothed round trip so that
+// the responses to requests sent at the previous limit don't count again.
+func (t *Throttle) decrease(reason string) {
+	t.successes = 0
+	if time.Since(t.lastDecrease) < t.latency {
+		return
+	}
+	t.lastDecrease = time.Now()
+	if t.limit == 1 {
+		return
+	}
+	t.limit = max(t.limit/2, 1)
+	t.record(reason)
+	t.logger.Info().Int("concurrency", t.limit).Str("reason", reason).Msg("Lowering concurrency")
+}
+
+// record adds the current limit to the history.
+func (t *Throttle) record(reason string) {
+	t.history = append(t.history, ConcurrencySample{Time: time.Now(), Limit: t.limit, Reason: reason})
+}
+
+// signal wakes requests waiting for a slot.
+func (t *Throttle) signal() {
+	close(t.wake)
+	t.wake = make(chan struct{})
+}
+
+// isTimeout reports whether a request failed because it took too long.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"collector/pkg/aci"
+	"collector/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleFixed(t *testing.T) {
+	a := assert.New(t)
+	throttle := NewThrottle(batchConfig(2))
+	a.Equal(2, throttle.Max())
+
+	a.NoError(throttle.acquire(context.Background()))
+	a.NoError(throttle.acquire(context.Background()))
+
+	// A third request waits for a free slot
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	a.ErrorIs(throttle.acquire(ctx), context.DeadlineExceeded)
+
+	throttle.release(context.Background(), time.Millisecond, nil)
+	a.NoError(throttle.acquire(context.Background()))
+
+	// Throttling doesn't change a fixed limit
+	throttle.release(context.Background(), time.Millisecond, &aci.APIError{StatusCode: 429})
+	a.Equal(2, throttle.Limit())
+}
+
+func TestThrottleAdaptive(t *testing.T) {
+	a := assert.New(t)
+	adaptive, maxConcurrency := true, 4
+	throttle := NewThrottle(config.FabricConfig{
+		AdaptiveConcurrency: &adaptive,
+		MaxConcurrency:      &maxConcurrency,
+	})
+	a.Equal(4, throttle.Max())
+	a.Equal(adaptiveStart, throttle.Limit())
+
+	// Each round of successful requests raises the limit by one, up to the max
+	for range 20 {
+		a.NoError(throttle.acquire(context.Background()))
+		throttle.release(context.Background(), time.Millisecond, nil)
+	}
+	a.Equal(4, throttle.Limit())
+
+	// Throttling halves the limit once per round trip
+	a.NoError(throttle.acquire(context.Background()))
+	throttle.release(context.Background(), time.Millisecond, &aci.APIError{StatusCode: 503})
+	a.Equal(2, throttle.Limit())
+
+	// Canceled requests don't count
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	throttle.lastDecrease = time.Time{}
+	a.NoError(throttle.acquire(context.Background()))
+	throttle.release(ctx, time.Millisecond, context.Canceled)
+	a.Equal(2, throttle.Limit())
+
+	history := throttle.History()
+	a.Equal("start", history[0].Reason)
+	a.Equal("throttled", history[len(history)-1].Reason)
+	a.Equal(2, history[len(history)-1].Limit)
+}
+
+func TestThrottleLatency(t *testing.T) {
+	a := assert.New(t)
+	adaptive := true
+	throttle := NewThrottle(config.FabricConfig{AdaptiveConcurrency: &adaptive})
+	for range 10 {
+		a.NoError(throttle.acquire(context.Background()))
+		throttle.release(context.Background(), 10*time.Millisecond, nil)
+	}
+	limit := throttle.Limit()
+
+	// Sharply rising latency lowers the limit
+	for range 10 {
+		a.NoError(throttle.acquire(context.Background()))
+		throttle.release(context.Background(), time.Second, nil)
+	}
+	a.Less(throttle.Limit(), limit)
+}
+
+func TestThrottleRate(t *testing.T) {
+	a := assert.New(t)
+	rps := 50.0
+	batchSize := 10
+	throttle := NewThrottle(config.FabricConfig{BatchSize: &batchSize, MaxRequestsPerSecond: &rps})
+
+	// Requests are spaced 20ms apart
+	start := time.Now()
+	for range 5 {
+		a.NoError(throttle.acquire(context.Background()))
+	}
+	a.GreaterOrEqual(time.Since(start), 80*time.Millisecond)
+}
+
+func TestThrottleBudget(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(NewBudget(0))
+
+	// Two fabrics share a budget of three requests
+	budget := NewBudget(3)
+	one := NewThrottle(batchConfig(2), SharedBudget(budget))
+	two := NewThrottle(batchConfig(2), SharedBudget(budget))
+	a.NoError(one.acquire(context.Background()))
+	a.NoError(one.acquire(context.Background()))
+	a.NoError(two.acquire(context.Background()))
+
+	// The second fabric has a free slot of its own, but the budget is spent
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	a.ErrorIs(two.acquire(ctx), context.DeadlineExceeded)
+	a.Equal(1, two.inFlight)
+
+	one.release(context.Background(), time.Millisecond, nil)
+	a.NoError(two.acquire(context.Background()))
+	a.Len(budget.slots, 3)
+}
@@ -4,9 +4,11 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/term"
 	"gopkg.in/yaml.v3"
@@ -14,33 +16,75 @@ import (
 
 // GlobalConfig holds global settings that apply to all fabrics.
 type GlobalConfig struct {
-	Username          string            `yaml:"username"`
-	Password          string            `yaml:"password"`
-	RequestRetryCount int               `yaml:"request_retry_count"`
-	RetryDelay        int               `yaml:"retry_delay"`
-	BatchSize         int               `yaml:"batch_size"`
-	PageSize          int               `yaml:"page_size"`
-	Confirm           bool              `yaml:"confirm"`
-	Verbose           bool              `yaml:"verbose"`
-	Class             string            `yaml:"class"`
-	Query             map[string]string `yaml:"query"`
+	Username             string            `yaml:"username"`
+	Password             string            `yaml:"password"`
+	PasswordFile         string            `yaml:"password_file"`
+	PasswordCommand      string            `yaml:"password_command"`
+	PrivateKey           string            `yaml:"private_key"`
+	CertName             string            `yaml:"cert_name"`
+	TLSVerify            bool              `yaml:"tls_verify"`
+	CABundle             string            `yaml:"ca_bundle"`
+	TLSMinVersion        string            `yaml:"tls_min_version"`
+	TLSTrustOnFirstUse   bool              `yaml:"tls_trust_on_first_use"`
+	DiscoverCluster      bool              `yaml:"discover_cluster"`
+	RequestRetryCount    int               `yaml:"request_retry_count"`
+	RetryDelay           int               `yaml:"retry_delay"`
+	MaxRetryDelay        int               `yaml:"max_retry_delay"`
+	BatchSize            int               `yaml:"batch_size"`
+	AdaptiveConcurrency  bool              `yaml:"adaptive_concurrency"`
+	MaxConcurrency       int               `yaml:"max_concurrency"`
+	MaxRequestsPerSecond float64           `yaml:"max_requests_per_second"`
+	PageSize             int               `yaml:"page_size"`
+	Priority             []string          `yaml:"priority"`
+	Confirm              bool              `yaml:"confirm"`
+	Verbose              bool              `yaml:"verbose"`
+	Class                string            `yaml:"class"`
+	Query                map[string]string `yaml:"query"`
+	Deadline             time.Duration     `yaml:"deadline"`
+	Resume               bool              `yaml:"resume"`
+	LogFile              string            `yaml:"log_file"`
+	JSON                 bool              `yaml:"json"`
+	NonInteractive       bool              `yaml:"non_interactive"`
+	Netrc                bool              `yaml:"netrc"`
+	MaxParallelFabrics   int               `yaml:"max_parallel_fabrics"`
+	RequestBudget        int               `yaml:"request_budget"`
+	FabricStartDelay     time.Duration     `yaml:"fabric_start_delay"`
 }
 
 // FabricConfig holds per-fabric configuration.
 type FabricConfig struct {
-	Name              string            `yaml:"name"`
-	URL               string            `yaml:"url"`
-	Output            string            `yaml:"output"`
-	Username          string            `yaml:"username"`
-	Password          string            `yaml:"password"`
-	RequestRetryCount *int              `yaml:"request_retry_count"`
-	RetryDelay        *int              `yaml:"retry_delay"`
-	BatchSize         *int              `yaml:"batch_size"`
-	PageSize          *int              `yaml:"page_size"`
-	Confirm           *bool             `yaml:"confirm"`
-	Verbose           *bool             `yaml:"verbose"`
-	Class             string            `yaml:"class"`
-	Query             map[string]string `yaml:"query"`
+	Name                 string            `yaml:"name"`
+	URL                  string            `yaml:"url"`
+	URLs                 []string          `yaml:"urls"`
+	Tags                 []string          `yaml:"tags"`
+	DiscoverCluster      *bool             `yaml:"discover_cluster"`
+	Output               string            `yaml:"output"`
+	Username             string            `yaml:"username"`
+	Password             string            `yaml:"password"`
+	PasswordFile         string            `yaml:"password_file"`
+	PasswordCommand      string            `yaml:"password_command"`
+	PrivateKey           string            `yaml:"private_key"`
+	CertName             string            `yaml:"cert_name"`
+	TLSVerify            *bool             `yaml:"tls_verify"`
+	CABundle             string            `yaml:"ca_bundle"`
+	TLSFingerprint       string            `yaml:"tls_fingerprint"`
+	TLSMinVersion        string            `yaml:"tls_min_version"`
+	TLSTrustOnFirstUse   *bool             `yaml:"tls_trust_on_first_use"`
+	RequestRetryCount    *int              `yaml:"request_retry_count"`
+	RetryDelay           *int              `yaml:"retry_delay"`
+	MaxRetryDelay        *int              `yaml:"max_retry_delay"`
+	BatchSize            *int              `yaml:"batch_size"`
+	AdaptiveConcurrency  *bool             `yaml:"adaptive_concurrency"`
+	MaxConcurrency       *int              `yaml:"max_concurrency"`
+	MaxRequestsPerSecond *float64          `yaml:"max_requests_per_second"`
+	PageSize             *int              `yaml:"page_size"`
+	Priority             []string          `yaml:"priority"`
+	Confirm              *bool             `yaml:"confirm"`
+	Verbose              *bool             `yaml:"verbose"`
+	Class                string            `yaml:"class"`
+	Query                map[string]string `yaml:"query"`
+	// ConfigFile is the YAML file this fabric was loaded from, if any.
+	ConfigFile string `yaml:"-"`
 }
 
 // Config represents the full YAML configuration file structure.
@@ -55,11 +99,14 @@ func New() Config {
 		Global: GlobalConfig{
 			RequestRetryCount: 3,
 			RetryDelay:        10,
+			MaxRetryDelay:     120,
 			BatchSize:         7,
+			MaxConcurrency:    32,
 			PageSize:          1000,
 			Confirm:           false,
 			Verbose:           false,
 			Class:             "all",
+			LogFile:           "aci-vetr.log",
 		},
 	}
 }
@@ -79,22 +126,46 @@ func LoadConfig(path string) (*Config, error) {
 }
 
 // ParseConfig reads and parses a YAML configuration file without prompting.
+// Unknown keys and invalid values are reported together in a *ValidationError.
 func ParseConfig(path string) (*Config, error) {
+	cfg, _, err := parseConfig(path)
+	return cfg, err
+}
+
+// parseConfig parses a configuration file and returns its YAML document too.
+func parseConfig(path string) (*Config, *yaml.Node, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if problems := checkDocument(&doc); len(problems) > 0 {
+		for i := range problems {
+			problems[i].Path = path
+		}
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if err := doc.Decode(&cfg); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for i := range cfg.Fabrics {
+		cfg.Fabrics[i].ConfigFile = path
 	}
 
 	cfg.ApplyDefaults()
-	return &cfg, nil
+	return &cfg, &doc, nil
 }
 
-// validateConfig ensures the configuration is valid.
+// validateConfig ensures the configuration is valid. The error is a *ValidationError
+// listing every problem, except when there are no fabrics at all.
 func validateConfig(cfg *Config, requireURL bool) error {
 	if len(cfg.Fabrics) == 0 {
 		return fmt.Errorf("no fabrics defined in config file")
@@ -102,28 +173,29 @@ func validateConfig(cfg *Config, requireURL bool) error {
 
 	// Track unique names/hosts
 	names := make(map[string]bool)
+	var problems []Problem
 
 	for i, fabric := range cfg.Fabrics {
-		if requireURL && fabric.URL == "" {
-			return fmt.Errorf("fabric %d: url is required", i)
+		if requireURL && len(fabric.GetURLs()) == 0 {
+			problems = append(problems, Problem{Message: fmt.Sprintf("fabric %d: url is required", i), fabric: i})
 		}
 
 		// Determine the derived name (name if set, otherwise url)
-		derivedName := fabric.Name
-		if derivedName == "" {
-			derivedName = fabric.URL
-		}
+		derivedName := fabric.GetFabricName()
 		if derivedName == "" {
 			derivedName = fmt.Sprintf("fabric-%d", i+1)
 		}
 
 		// Check for duplicate names
 		if names[derivedName] {
-			return fmt.Errorf("duplicate fabric name/url: %s", derivedName)
+			problems = append(problems, Problem{Message: "duplicate fabric name/url: " + derivedName, fabric: i})
 		}
 		names[derivedName] = true
 	}
 
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
 	return nil
 }
 
@@ -132,9 +204,26 @@ func (f *FabricConfig) GetFabricName() string {
 	if f.Name != "" {
 		return f.Name
 	}
+	if f.URL == "" && len(f.URLs) > 0 {
+		return f.URLs[0]
+	}
 	return f.URL
 }
 
+// GetURLs returns all APIC addresses of the fabric, url first followed by urls.
+func (f *FabricConfig) GetURLs() []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, url := range append([]string{f.URL}, f.URLs...) {
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		urls = append(urls, url)
+	}
+	return urls
+}
+
 // GetOutputFileName returns the output filename for a fabric.
 func (f *FabricConfig) GetOutputFileName() string {
 	if f.Output != "" {
@@ -151,8 +240,32 @@ func (f *FabricConfig) MergeWithGlobal(global GlobalConfig) FabricConfig {
 	if merged.Username == "" {
 		merged.Username = global.Username
 	}
-	if merged.Password == "" {
+	// The password sources of a fabric replace the global ones
+	if merged.Password == "" && merged.PasswordFile == "" && merged.PasswordCommand == "" {
 		merged.Password = global.Password
+		merged.PasswordFile = global.PasswordFile
+		merged.PasswordCommand = global.PasswordCommand
+	}
+	if merged.PrivateKey == "" {
+		merged.PrivateKey = global.PrivateKey
+	}
+	if merged.CertName == "" {
+		merged.CertName = global.CertName
+	}
+	if merged.TLSVerify == nil {
+		merged.TLSVerify = &global.TLSVerify
+	}
+	if merged.CABundle == "" {
+		merged.CABundle = global.CABundle
+	}
+	if merged.TLSMinVersion == "" {
+		merged.TLSMinVersion = global.TLSMinVersion
+	}
+	if merged.TLSTrustOnFirstUse == nil {
+		merged.TLSTrustOnFirstUse = &global.TLSTrustOnFirstUse
+	}
+	if merged.DiscoverCluster == nil {
+		merged.DiscoverCluster = &global.DiscoverCluster
 	}
 	if merged.RequestRetryCount == nil {
 		merged.RequestRetryCount = &global.RequestRetryCount
@@ -160,12 +273,27 @@ func (f *FabricConfig) MergeWithGlobal(global GlobalConfig) FabricConfig {
 	if merged.RetryDelay == nil {
 		merged.RetryDelay = &global.RetryDelay
 	}
+	if merged.MaxRetryDelay == nil {
+		merged.MaxRetryDelay = &global.MaxRetryDelay
+	}
 	if merged.BatchSize == nil {
 		merged.BatchSize = &global.BatchSize
 	}
+	if merged.AdaptiveConcurrency == nil {
+		merged.AdaptiveConcurrency = &global.AdaptiveConcurrency
+	}
+	if merged.MaxConcurrency == nil {
+		merged.MaxConcurrency = &global.MaxConcurrency
+	}
+	if merged.MaxRequestsPerSecond == nil {
+		merged.MaxRequestsPerSecond = &global.MaxRequestsPerSecond
+	}
 	if merged.PageSize == nil {
 		merged.PageSize = &global.PageSize
 	}
+	if merged.Priority == nil {
+		merged.Priority = global.Priority
+	}
 	if merged.Confirm == nil {
 		merged.Confirm = &global.Confirm
 	}
@@ -182,6 +310,113 @@ func (f *FabricConfig) MergeWithGlobal(global GlobalConfig) FabricConfig {
 	return merged
 }
 
+// maskedSecret stands in for passwords in the effective config.
+const maskedSecret = "********"
+
+// Effective returns the config with every fabric merged with the global settings,
+// as they are used for the collection.
+func (c *Config) Effective() Config {
+	effective := Config{Global: c.Global, Fabrics: make([]FabricConfig, len(c.Fabrics))}
+	for i := range c.Fabrics {
+		fabric := c.Fabrics[i].MergeWithGlobal(c.Global)
+		fabric.Output = fabric.GetOutputFileName()
+		effective.Fabrics[i] = fabric
+	}
+	return effective
+}
+
+// WriteEffective writes the effective settings of every fabric as YAML, with passwords
+// masked, preceded by the global settings that don't apply to a single fabric.
+func (c *Config) WriteEffective(w io.Writer) error {
+	effective := c.Effective()
+	for i := range effective.Fabrics {
+		if effective.Fabrics[i].Password != "" {
+			effective.Fabrics[i].Password = maskedSecret
+		}
+	}
+	type runSettings struct {
+		Deadline           time.Duration `yaml:"deadline"`
+		Resume             bool          `yaml:"resume"`
+		LogFile            string        `yaml:"log_file"`
+		JSON               bool          `yaml:"json"`
+		NonInteractive     bool          `yaml:"non_interactive"`
+		Netrc              bool          `yaml:"netrc"`
+		MaxParallelFabrics int           `yaml:"max_parallel_fabrics"`
+		RequestBudget      int           `yaml:"request_budget"`
+		FabricStartDelay   time.Duration `yaml:"fabric_start_delay"`
+	}
+	doc := struct {
+		Global  runSettings    `yaml:"global"`
+		Fabrics []FabricConfig `yaml:"fabrics"`
+	}{
+		Global: runSettings{
+			Deadline:           c.Global.Deadline,
+			Resume:             c.Global.Resume,
+			LogFile:            c.Global.LogFile,
+			JSON:               c.Global.JSON,
+			NonInteractive:     c.Global.NonInteractive,
+			Netrc:              c.Global.Netrc,
+			MaxParallelFabrics: c.Global.MaxParallelFabrics,
+			RequestBudget:      c.Global.RequestBudget,
+			FabricStartDelay:   c.Global.FabricStartDelay,
+		},
+		Fabrics: effective.Fabrics,
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Write writes the config as YAML, leaving out settings that are not set.
+// Global settings are plain values, so false and 0 are left out too.
+func (c *Config) Write(w io.Writer) error {
+	var doc yaml.Node
+	if err := doc.Encode(c); err != nil {
+		return err
+	}
+	pruneYAML(mappingValue(&doc, "global"), true)
+	pruneYAML(&doc, false)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// pruneYAML removes the keys of mappings whose values are null, empty strings,
+// or empty sequences or mappings, including mappings emptied by pruning.
+// With zero, false and 0 are removed as well.
+func pruneYAML(node *yaml.Node, zero bool) {
+	if node == nil {
+		return
+	}
+	for _, child := range node.Content {
+		pruneYAML(child, zero)
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	var content []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		value := node.Content[i+1]
+		empty := value.Tag == "!!null" ||
+			value.Kind == yaml.ScalarNode && value.Tag == "!!str" && value.Value == "" ||
+			(value.Kind == yaml.SequenceNode || value.Kind == yaml.MappingNode) && len(value.Content) == 0
+		if zero && value.Kind == yaml.ScalarNode {
+			empty = empty || value.Tag != "!!str" && (value.Value == "false" || value.Value == "0") ||
+				value.Value == "0s"
+		}
+		if !empty {
+			content = append(content, node.Content[i], value)
+		}
+	}
+	node.Content = content
+}
+
 // ApplyDefaults sets global defaults for missing values.
 func (c *Config) ApplyDefaults() {
 	defaults := New().Global
@@ -191,38 +426,102 @@ func (c *Config) ApplyDefaults() {
 	if c.Global.RetryDelay == 0 {
 		c.Global.RetryDelay = defaults.RetryDelay
 	}
+	if c.Global.MaxRetryDelay == 0 {
+		c.Global.MaxRetryDelay = defaults.MaxRetryDelay
+	}
 	if c.Global.BatchSize == 0 {
 		c.Global.BatchSize = defaults.BatchSize
 	}
+	if c.Global.MaxConcurrency == 0 {
+		c.Global.MaxConcurrency = defaults.MaxConcurrency
+	}
 	if c.Global.PageSize == 0 {
 		c.Global.PageSize = defaults.PageSize
 	}
 	if c.Global.Class == "" {
 		c.Global.Class = defaults.Class
 	}
+	if c.Global.LogFile == "" {
+		c.Global.LogFile = defaults.LogFile
+	}
+}
+
+// MissingValuesError lists the values that would have been prompted for in non-interactive mode.
+type MissingValuesError struct {
+	Missing []string
+}
+
+func (e *MissingValuesError) Error() string {
+	return fmt.Sprintf("missing required values in non-interactive mode: %s "+
+		"(set them in the config file, on the command line or in the environment)", strings.Join(e.Missing, "; "))
 }
 
-// NormalizeAndPrompt fills missing values and normalizes inputs.
+// prompter asks for missing values, or in non-interactive mode records them as missing.
+type prompter struct {
+	nonInteractive bool
+	missing        []string
+}
+
+func (p *prompter) input(prompt string) string {
+	if p.nonInteractive {
+		p.missing = append(p.missing, strings.TrimSuffix(prompt, ":"))
+		return ""
+	}
+	return input(prompt)
+}
+
+func (p *prompter) password(prompt string) string {
+	if p.nonInteractive {
+		p.missing = append(p.missing, strings.TrimSuffix(prompt, ":"))
+		return ""
+	}
+	return inputPassword(prompt)
+}
+
+// NormalizeAndPrompt resolves secrets, fills missing values and normalizes inputs.
+// Passwords are read from password_file, password_command and the netrc file
+// before prompting for what is still missing.
+// In non-interactive mode it doesn't prompt and returns a *MissingValuesError
+// listing every missing value instead.
 func (c *Config) NormalizeAndPrompt() error {
 	c.ApplyDefaults()
 	if len(c.Fabrics) == 0 {
 		return fmt.Errorf("no fabrics defined in config file")
 	}
+	if err := c.resolveSecrets(); err != nil {
+		return err
+	}
+	p := &prompter{nonInteractive: c.Global.NonInteractive}
 
 	// Prompt for missing URLs and normalize.
 	for i := range c.Fabrics {
 		label := c.fabricLabel(i)
-		if c.Fabrics[i].URL == "" {
-			c.Fabrics[i].URL = input(fmt.Sprintf("APIC URL for %s:", label))
+		if len(c.Fabrics[i].GetURLs()) == 0 {
+			c.Fabrics[i].URL = p.input(fmt.Sprintf("APIC URL for %s:", label))
 		}
 		c.Fabrics[i].URL = normalizeURL(c.Fabrics[i].URL)
+		for j := range c.Fabrics[i].URLs {
+			c.Fabrics[i].URLs[j] = normalizeURL(c.Fabrics[i].URLs[j])
+		}
+	}
+
+	if c.Global.Netrc {
+		if err := c.applyNetrc(); err != nil {
+			return err
+		}
 	}
 
 	// Prompt once for username/password if none provided.
 	if !c.hasAnyUsername() {
 		label := c.fabricLabel(0)
-		c.Global.Username = input(fmt.Sprintf("APIC username for %s (applies to all fabrics):", label))
-		c.Global.Password = inputPassword(fmt.Sprintf("APIC password for %s (applies to all fabrics):", label))
+		c.Global.Username = p.input(fmt.Sprintf("APIC username for %s (applies to all fabrics):", label))
+		if c.anyPasswordAuth() {
+			c.Global.Password = p.password(fmt.Sprintf("APIC password for %s (applies to all fabrics):", label))
+		}
+		// Without any username, per-fabric usernames and passwords would only repeat it
+		if p.nonInteractive {
+			return &MissingValuesError{Missing: p.missing}
+		}
 	}
 
 	// Apply global username to fabrics when missing.
@@ -238,7 +537,7 @@ func (c *Config) NormalizeAndPrompt() error {
 	for i := range c.Fabrics {
 		if c.Fabrics[i].Username == "" {
 			label := c.fabricLabel(i)
-			c.Fabrics[i].Username = input(fmt.Sprintf("APIC username for %s:", label))
+			c.Fabrics[i].Username = p.input(fmt.Sprintf("APIC username for %s:", label))
 		}
 	}
 
@@ -265,7 +564,7 @@ func (c *Config) NormalizeAndPrompt() error {
 		if user == "" {
 			continue
 		}
-		if c.Fabrics[i].Password != "" {
+		if c.Fabrics[i].Password != "" || c.usesCertAuth(i) {
 			continue
 		}
 		if pw, ok := passwordByUser[user]; ok {
@@ -273,7 +572,7 @@ func (c *Config) NormalizeAndPrompt() error {
 			continue
 		}
 		label := c.fabricLabel(i)
-		pw := inputPassword(fmt.Sprintf("APIC password for %s (%s):", user, label))
+		pw := p.password(fmt.Sprintf("APIC password for %s (%s):", user, label))
 		passwordByUser[user] = pw
 		c.Fabrics[i].Password = pw
 	}
@@ -294,6 +593,9 @@ func (c *Config) NormalizeAndPrompt() error {
 		}
 	}
 
+	if len(p.missing) > 0 {
+		return &MissingValuesError{Missing: p.missing}
+	}
 	return validateConfig(c, true)
 }
 
@@ -313,6 +615,14 @@ func (f *FabricConfig) GetRetryDelay() int {
 	return 10 // default
 }
 
+// GetMaxRetryDelay returns the upper bound of the retry delay in seconds with fallback to default.
+func (f *FabricConfig) GetMaxRetryDelay() int {
+	if f.MaxRetryDelay != nil && *f.MaxRetryDelay > 0 {
+		return *f.MaxRetryDelay
+	}
+	return 120 // default
+}
+
 // GetBatchSize returns the batch size with fallback to default.
 func (f *FabricConfig) GetBatchSize() int {
 	if f.BatchSize != nil {
@@ -321,6 +631,30 @@ func (f *FabricConfig) GetBatchSize() int {
 	return 7 // default
 }
 
+// GetAdaptiveConcurrency returns the adaptive concurrency flag with fallback to default.
+func (f *FabricConfig) GetAdaptiveConcurrency() bool {
+	if f.AdaptiveConcurrency != nil {
+		return *f.AdaptiveConcurrency
+	}
+	return false // default
+}
+
+// GetMaxConcurrency returns the adaptive concurrency limit with fallback to default.
+func (f *FabricConfig) GetMaxConcurrency() int {
+	if f.MaxConcurrency != nil && *f.MaxConcurrency > 0 {
+		return *f.MaxConcurrency
+	}
+	return 32 // default
+}
+
+// GetMaxRequestsPerSecond returns the request rate cap, 0 for no cap.
+func (f *FabricConfig) GetMaxRequestsPerSecond() float64 {
+	if f.MaxRequestsPerSecond != nil {
+		return *f.MaxRequestsPerSecond
+	}
+	return 0 // default
+}
+
 // GetPageSize returns the page size with fallback to default.
 func (f *FabricConfig) GetPageSize() int {
 	if f.PageSize != nil {
@@ -345,6 +679,31 @@ func (f *FabricConfig) GetVerbose() bool {
 	return false // default
 }
 
+// GetTLSVerify returns whether the APIC certificate is verified.
+// Verification is implied by a CA bundle, a fingerprint pin or trust-on-first-use.
+func (f *FabricConfig) GetTLSVerify() bool {
+	if f.TLSVerify != nil && *f.TLSVerify {
+		return true
+	}
+	return f.CABundle != "" || f.TLSFingerprint != "" || f.GetTLSTrustOnFirstUse()
+}
+
+// GetTLSTrustOnFirstUse returns the trust-on-first-use flag with fallback to default.
+func (f *FabricConfig) GetTLSTrustOnFirstUse() bool {
+	if f.TLSTrustOnFirstUse != nil {
+		return *f.TLSTrustOnFirstUse
+	}
+	return false // default
+}
+
+// GetDiscoverCluster returns the cluster discovery flag with fallback to default.
+func (f *FabricConfig) GetDiscoverCluster() bool {
+	if f.DiscoverCluster != nil {
+		return *f.DiscoverCluster
+	}
+	return false // default
+}
+
 // GetClass returns the class with fallback to default.
 func (f *FabricConfig) GetClass() string {
 	if f.Class != "" {
@@ -353,6 +712,25 @@ func (f *FabricConfig) GetClass() string {
 	return "all" // default
 }
 
+// UsesCertAuth reports whether the fabric authenticates with a private key and certificate name.
+func (f *FabricConfig) UsesCertAuth() bool {
+	return f.PrivateKey != "" && f.CertName != ""
+}
+
+func (c *Config) usesCertAuth(index int) bool {
+	merged := c.Fabrics[index].MergeWithGlobal(c.Global)
+	return merged.UsesCertAuth()
+}
+
+func (c *Config) anyPasswordAuth() bool {
+	for i := range c.Fabrics {
+		if !c.usesCertAuth(i) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) hasAnyUsername() bool {
 	if c.Global.Username != "" {
 		return true
@@ -373,23 +751,23 @@ func (c *Config) fabricLabel(index int) string {
 	return label
 }
 
-// input collects CLI input.
+// input collects CLI input. Prompts go to stderr to keep stdout for results.
 func input(prompt string) string {
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Printf("%s ", prompt)
+	fmt.Fprintf(os.Stderr, "%s ", prompt)
 	input, _ := reader.ReadString('\n')
 	return strings.Trim(input, "\r\n")
 }
 
 func inputPassword(prompt string) string {
-	fmt.Print(prompt + " ")
+	fmt.Fprint(os.Stderr, prompt+" ")
 	pwd, _ := term.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+	fmt.Fprintln(os.Stderr)
 	return string(pwd)
 }
 
 func normalizeURL(url string) string {
 	url, _ = strings.CutPrefix(url, "http://")
 	url, _ = strings.CutPrefix(url, "https://")
-	return url
+	return strings.TrimSuffix(url, "/")
 }
@@ -3,9 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -245,3 +247,153 @@ func TestGetters(t *testing.T) {
 	a.False(fabric.GetVerbose())
 	a.Equal("all", fabric.GetClass())
 }
+
+func TestUsesCertAuth(t *testing.T) {
+	a := assert.New(t)
+
+	global := GlobalConfig{PrivateKey: "admin.key"}
+	fabric := FabricConfig{CertName: "admin-cert"}
+	a.False(fabric.UsesCertAuth())
+
+	// Key from global, certificate name from fabric
+	merged := fabric.MergeWithGlobal(global)
+	a.True(merged.UsesCertAuth())
+	a.Equal("admin.key", merged.PrivateKey)
+	a.Equal("admin-cert", merged.CertName)
+}
+
+func TestRecordFingerprints(t *testing.T) {
+	a := assert.New(t)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	config := `
+global:
+  tls_trust_on_first_use: true
+fabrics:
+  # Production fabric
+  - name: fabric1
+    url: 10.1.1.1
+  - url: https://10.2.2.2
+    tls_fingerprint: old
+`
+	err := os.WriteFile(configPath, []byte(config), 0600)
+	a.NoError(err)
+
+	a.NoError(RecordFingerprints(configPath, map[string]string{"fabric1": "aa11", "10.2.2.2": "bb22"}))
+	a.Error(RecordFingerprints(configPath, map[string]string{"missing": "cc33"}))
+
+	cfg, err := LoadConfig(configPath)
+	a.NoError(err)
+	a.Equal("aa11", cfg.Fabrics[0].TLSFingerprint)
+	a.Equal("bb22", cfg.Fabrics[1].TLSFingerprint)
+	a.True(cfg.Global.TLSTrustOnFirstUse)
+	a.Equal(configPath, cfg.Fabrics[0].ConfigFile)
+
+	// Comments and permissions are preserved
+	data, _ := os.ReadFile(configPath)
+	a.Contains(string(data), "# Production fabric")
+	info, _ := os.Stat(configPath)
+	a.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// Several fabrics are written at once, missing fabrics are reported
+	err = RecordFingerprints(configPath, map[string]string{"fabric1": "cc33", "10.2.2.2": "dd44", "missing": "ee55"})
+	a.EqualError(err, "fabric missing not found in "+configPath)
+	cfg, err = LoadConfig(configPath)
+	a.NoError(err)
+	a.Equal("cc33", cfg.Fabrics[0].TLSFingerprint)
+	a.Equal("dd44", cfg.Fabrics[1].TLSFingerprint)
+}
+
+func TestGetURLs(t *testing.T) {
+	a := assert.New(t)
+
+	fabric := FabricConfig{
+		URL:  "10.1.1.1",
+		URLs: []string{"10.1.1.2", "10.1.1.1", "10.1.1.3"},
+	}
+	a.Equal([]string{"10.1.1.1", "10.1.1.2", "10.1.1.3"}, fabric.GetURLs())
+	a.Equal("10.1.1.1", fabric.GetFabricName())
+
+	// Only urls set
+	fabric = FabricConfig{URLs: []string{"10.2.2.1", "10.2.2.2"}}
+	a.Equal([]string{"10.2.2.1", "10.2.2.2"}, fabric.GetURLs())
+	a.Equal("10.2.2.1", fabric.GetFabricName())
+}
+
+func TestNormalizeNonInteractive(t *testing.T) {
+	a := assert.New(t)
+
+	// Every missing value is listed instead of prompted for
+	cfg := Config{
+		Global: GlobalConfig{NonInteractive: true},
+		Fabrics: []FabricConfig{
+			{Name: "one", URL: "10.1.1.1", Username: "admin"},
+			{Name: "two", Username: "ro"},
+			{Name: "three", URL: "10.3.3.3", Username: "admin"},
+			{Name: "four", URL: "10.4.4.4", Username: "cert", PrivateKey: "key.pem", CertName: "cert"},
+		},
+	}
+	err := cfg.NormalizeAndPrompt()
+	var missing *MissingValuesError
+	a.ErrorAs(err, &missing)
+	a.Equal([]string{
+		"APIC URL for two",
+		"APIC password for admin (one)",
+		"APIC password for ro (two)",
+	}, missing.Missing)
+
+	// Without any username only the shared credentials are listed
+	cfg = Config{
+		Global:  GlobalConfig{NonInteractive: true},
+		Fabrics: []FabricConfig{{Name: "one", URL: "10.1.1.1"}, {Name: "two", URL: "10.2.2.2"}},
+	}
+	err = cfg.NormalizeAndPrompt()
+	a.ErrorAs(err, &missing)
+	a.Equal([]string{
+		"APIC username for one (applies to all fabrics)",
+		"APIC password for one (applies to all fabrics)",
+	}, missing.Missing)
+
+	// Complete configs pass
+	cfg = Config{
+		Global:  GlobalConfig{NonInteractive: true, Username: "admin", Password: "secret"},
+		Fabrics: []FabricConfig{{Name: "one", URL: "10.1.1.1"}},
+	}
+	a.NoError(cfg.NormalizeAndPrompt())
+	a.Equal("secret", cfg.Fabrics[0].Password)
+}
+
+func TestWriteEffective(t *testing.T) {
+	a := assert.New(t)
+
+	batchSize := 3
+	cfg := New()
+	cfg.Global.Username = "admin"
+	cfg.Global.Password = "secret"
+	cfg.Global.Resume = true
+	cfg.Fabrics = []FabricConfig{
+		{Name: "one", URL: "10.1.1.1", BatchSize: &batchSize},
+		{Name: "two", URL: "10.2.2.2", Username: "ro", PasswordFile: "ro.password"},
+	}
+
+	effective := cfg.Effective()
+	a.Equal(3, effective.Fabrics[0].GetBatchSize())
+	a.Equal(7, effective.Fabrics[1].GetBatchSize())
+	a.Equal("one.zip", effective.Fabrics[0].Output)
+	a.Equal("secret", effective.Fabrics[0].Password)
+
+	var buf strings.Builder
+	a.NoError(cfg.WriteEffective(&buf))
+	a.NotContains(buf.String(), "secret")
+
+	var written Config
+	a.NoError(yaml.Unmarshal([]byte(buf.String()), &written))
+	a.True(written.Global.Resume)
+	a.Equal(maskedSecret, written.Fabrics[0].Password)
+	a.Equal("admin", written.Fabrics[0].Username)
+	a.Equal(3, written.Fabrics[0].GetBatchSize())
+	a.Equal("", written.Fabrics[1].Password)
+	a.Equal("ro.password", written.Fabrics[1].PasswordFile)
+}
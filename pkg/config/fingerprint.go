@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fingerprintMu serializes the updates of config files by RecordFingerprints.
+var fingerprintMu sync.Mutex
+
+// RecordFingerprints writes the trust-on-first-use fingerprints of several fabrics,
+// keyed by fabric name, into the YAML config file in a single update.
+// Fabrics are matched by name, or by url for fabrics without a name.
+// Fabrics not found in the file are reported, the others are still written.
+// Comments and ordering of the rest of the file are preserved.
+func RecordFingerprints(path string, fingerprints map[string]string) error {
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return fmt.Errorf("config file %s is empty", path)
+	}
+
+	var errs []error
+	fabrics := mappingValue(doc.Content[0], "fabrics")
+	for _, fabricName := range slices.Sorted(maps.Keys(fingerprints)) {
+		fabric := findFabricNode(fabrics, fabricName)
+		if fabric == nil {
+			errs = append(errs, fmt.Errorf("fabric %s not found in %s", fabricName, path))
+			continue
+		}
+		setMappingValue(fabric, "tls_fingerprint", fingerprints[fabricName])
+	}
+	if len(errs) == len(fingerprints) {
+		return errors.Join(errs...)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// findFabricNode returns the mapping node of the named fabric in the fabrics sequence.
+func findFabricNode(fabrics *yaml.Node, fabricName string) *yaml.Node {
+	if fabrics == nil || fabrics.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, fabric := range fabrics.Content {
+		name := mappingValue(fabric, "name")
+		if name != nil && name.Value != "" {
+			if name.Value == fabricName {
+				return fabric
+			}
+			continue
+		}
+		url := mappingValue(fabric, "url")
+		if url != nil && normalizeURL(url.Value) == normalizeURL(fabricName) {
+			return fabric
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value node for a key in a mapping node.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets a string value for a key in a mapping node, adding the key if missing.
+func setMappingValue(node *yaml.Node, key, value string) {
+	if existing := mappingValue(node, key); existing != nil {
+		existing.Kind = yaml.ScalarNode
+		existing.Tag = "!!str"
+		existing.Value = value
+		return
+	}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+	)
+}
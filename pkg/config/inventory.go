@@ -0,0 +1,541 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryAliases maps the variables commonly used for APICs in Ansible inventories,
+// including the parameters of the cisco.aci modules, to collector settings.
+// Earlier variables take precedence over later ones for the same setting.
+var inventoryAliases = []struct {
+	name    string
+	setting string
+}{
+	{"aci_host", "url"},
+	{"aci_hostname", "url"},
+	{"host", "url"},
+	{"ansible_host", "url"},
+	{"aci_username", "username"},
+	{"aci_user", "username"},
+	{"ansible_user", "username"},
+	{"aci_password", "password"},
+	{"aci_private_key", "private_key"},
+	{"aci_certificate_name", "cert_name"},
+	{"certificate_name", "cert_name"},
+	{"aci_validate_certs", "tls_verify"},
+	{"validate_certs", "tls_verify"},
+	// Not ansible_port, which is usually the SSH port
+	{"aci_port", "port"},
+}
+
+// fabricSettings maps the YAML keys of the fabric settings to their types.
+var fabricSettings = yamlFields(reflect.TypeFor[FabricConfig]())
+
+// ParseCSV reads fabrics from a CSV file with a header row, e.g. a CMDB export.
+// Columns are matched to fabric settings by name, e.g. name, url, username and tags;
+// other columns are ignored. List settings such as tags and urls are separated by
+// semicolons.
+func ParseCSV(path string) ([]FabricConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	if !slices.Contains(header, "url") {
+		return nil, fmt.Errorf("inventory %s has no url column", path)
+	}
+
+	var fabrics []FabricConfig
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse inventory: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		vars := make(map[string]any)
+		for i, value := range record {
+			if value = strings.TrimSpace(value); value != "" {
+				vars[header[i]] = value
+			}
+		}
+		if len(vars) == 0 {
+			continue
+		}
+		fabric, err := fabricFromVars("", vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		fabrics = append(fabrics, fabric)
+	}
+	return fabrics, validateInventory(path, fabrics)
+}
+
+// ParseAnsibleInventory reads fabrics from the hosts of a group of an Ansible
+// inventory, in YAML (.yml, .yaml, .json) or INI format. Each host is a fabric
+// named after the host. Variables of the host and its groups named like fabric
+// settings, or like the usual Ansible and cisco.aci variables (ansible_host,
+// aci_username, validate_certs, ...), are mapped onto the fabric; the groups of
+// the host become its tags. Templated and vault-encrypted values are ignored.
+func ParseAnsibleInventory(path, group string) ([]FabricConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+	inv := newInventory()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml", ".json":
+		err = inv.parseYAML(data)
+	default:
+		err = inv.parseINI(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %w", err)
+	}
+
+	if group == "" {
+		group = "all"
+	}
+	if inv.groups[group] == nil {
+		return nil, fmt.Errorf("group %s not found in %s", group, path)
+	}
+	var fabrics []FabricConfig
+	for _, host := range inv.members(group) {
+		fabric, err := fabricFromVars(host, inv.vars(host))
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", host, err)
+		}
+		for _, tag := range inv.groupsOf(host) {
+			if !slices.Contains(fabric.Tags, tag) {
+				fabric.Tags = append(fabric.Tags, tag)
+			}
+		}
+		fabrics = append(fabrics, fabric)
+	}
+	if len(fabrics) == 0 {
+		return nil, fmt.Errorf("group %s of %s has no hosts", group, path)
+	}
+	return fabrics, validateInventory(path, fabrics)
+}
+
+// validateInventory checks the fabrics read from an inventory like those of a config file.
+func validateInventory(path string, fabrics []FabricConfig) error {
+	if err := validateConfig(&Config{Fabrics: fabrics}, true); err != nil {
+		return fmt.Errorf("inventory %s: %w", path, err)
+	}
+	return nil
+}
+
+// fabricFromVars builds a fabric from inventory variables. Values are strings or,
+// for lists, []string.
+func fabricFromVars(name string, vars map[string]any) (FabricConfig, error) {
+	settings := make(map[string]any)
+	for key, value := range vars {
+		if _, ok := fabricSettings[key]; ok {
+			settings[key] = value
+		}
+	}
+	for _, alias := range inventoryAliases {
+		if value, ok := vars[alias.name]; ok {
+			if _, set := settings[alias.setting]; !set {
+				settings[alias.setting] = value
+			}
+		}
+	}
+	if _, ok := settings["name"]; !ok && name != "" {
+		settings["name"] = name
+	}
+	if _, ok := settings["url"]; !ok && name != "" {
+		settings["url"] = name
+	}
+	// The client connects to the port of the URL, 443 by default
+	if port, ok := settings["port"].(string); ok {
+		url, _ := settings["url"].(string)
+		if url != "" && port != "443" && !strings.Contains(normalizeURL(url), ":") {
+			settings["url"] = url + ":" + port
+		}
+	}
+
+	// Decode through YAML, so that values are parsed as in a config file
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		t, ok := fabricSettings[key]
+		if !ok {
+			continue
+		}
+		value := settingNode(t, settings[key])
+		if value == nil {
+			continue
+		}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	}
+	var fabric FabricConfig
+	if err := mapping.Decode(&fabric); err != nil {
+		return FabricConfig{}, err
+	}
+	return fabric, nil
+}
+
+// settingNode returns the YAML node of a setting value, nil if the setting can't
+// be set from an inventory.
+func settingNode(t reflect.Type, value any) *yaml.Node {
+	str := func(s string) *yaml.Node { return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s} }
+	switch t.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			return str(s)
+		}
+	case reflect.Slice:
+		list, ok := value.([]string)
+		if s, isString := value.(string); isString {
+			list, ok = splitInventoryList(s), true
+		}
+		if !ok {
+			return nil
+		}
+		seq := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range list {
+			seq.Content = append(seq.Content, str(item))
+		}
+		return seq
+	case reflect.Pointer:
+		// Booleans and numbers are resolved from the plain scalar
+		if s, ok := value.(string); ok {
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: strings.ToLower(s)}
+		}
+	}
+	return nil
+}
+
+// splitInventoryList splits a list given as a string, e.g. "emea;prod" in a CSV
+// file or "['emea', 'prod']" in an INI inventory.
+func splitInventoryList(s string) []string {
+	s = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "["), "]")
+	var list []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' }) {
+		if item = strings.Trim(strings.TrimSpace(item), `'"`); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// inventory is an Ansible inventory.
+type inventory struct {
+	// hosts in order of first appearance
+	hosts    []string
+	hostVars map[string]map[string]any
+	groups   map[string]*inventoryGroup
+}
+
+type inventoryGroup struct {
+	hosts    []string
+	children []string
+	vars     map[string]any
+}
+
+func newInventory() *inventory {
+	inv := &inventory{hostVars: make(map[string]map[string]any), groups: make(map[string]*inventoryGroup)}
+	inv.group("all")
+	return inv
+}
+
+// group returns a group, adding it if missing.
+func (inv *inventory) group(name string) *inventoryGroup {
+	if inv.groups[name] == nil {
+		inv.groups[name] = &inventoryGroup{vars: make(map[string]any)}
+	}
+	return inv.groups[name]
+}
+
+// addHost adds a host to a group and merges its variables.
+func (inv *inventory) addHost(group, host string, vars map[string]any) {
+	if inv.hostVars[host] == nil {
+		inv.hosts = append(inv.hosts, host)
+		inv.hostVars[host] = make(map[string]any)
+	}
+	for key, value := range vars {
+		inv.hostVars[host][key] = value
+	}
+	g := inv.group(group)
+	if !slices.Contains(g.hosts, host) {
+		g.hosts = append(g.hosts, host)
+	}
+}
+
+// addChild makes child a child group of parent.
+func (inv *inventory) addChild(parent, child string) {
+	inv.group(child)
+	g := inv.group(parent)
+	if !slices.Contains(g.children, child) {
+		g.children = append(g.children, child)
+	}
+}
+
+// members returns the hosts of a group and its descendants, in order of appearance.
+func (inv *inventory) members(group string) []string {
+	var members []string
+	for _, host := range inv.hosts {
+		if group == "all" || slices.Contains(inv.ancestors(host), group) {
+			members = append(members, host)
+		}
+	}
+	return members
+}
+
+// ancestorDepths returns the groups a host belongs to, directly or through child groups,
+// with their distance from the host: 0 for its own groups, 1 for their parents and so on.
+func (inv *inventory) ancestorDepths(host string) map[string]int {
+	depths := make(map[string]int)
+	var visit func(group string, depth int)
+	visit = func(group string, depth int) {
+		if d, seen := depths[group]; seen && d >= depth {
+			return
+		}
+		depths[group] = depth
+		for name, g := range inv.groups {
+			if slices.Contains(g.children, group) {
+				visit(name, depth+1)
+			}
+		}
+	}
+	for name, g := range inv.groups {
+		if slices.Contains(g.hosts, host) {
+			visit(name, 0)
+		}
+	}
+	return depths
+}
+
+// ancestors returns the groups a host belongs to, directly or through child groups.
+func (inv *inventory) ancestors(host string) []string {
+	var groups []string
+	for group := range inv.ancestorDepths(host) {
+		groups = append(groups, group)
+	}
+	slices.Sort(groups)
+	return groups
+}
+
+// groupsOf returns the groups of a host to use as tags, without all and ungrouped.
+func (inv *inventory) groupsOf(host string) []string {
+	return slices.DeleteFunc(inv.ancestors(host), func(group string) bool {
+		return group == "all" || group == "ungrouped"
+	})
+}
+
+// vars returns the variables of a host: those of all, then of its groups from the
+// outermost to the innermost, then its own, later ones taking precedence as in Ansible.
+func (inv *inventory) vars(host string) map[string]any {
+	depths := inv.ancestorDepths(host)
+	groups := inv.ancestors(host)
+	slices.SortStableFunc(groups, func(a, b string) int { return depths[b] - depths[a] })
+	vars := make(map[string]any)
+	for key, value := range inv.groups["all"].vars {
+		vars[key] = value
+	}
+	for _, group := range groups {
+		for key, value := range inv.groups[group].vars {
+			vars[key] = value
+		}
+	}
+	for key, value := range inv.hostVars[host] {
+		vars[key] = value
+	}
+	return vars
+}
+
+// parseYAML reads an inventory in YAML format.
+func (inv *inventory) parseYAML(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("inventory is not a mapping of groups")
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name := root.Content[i].Value
+		if name != "all" {
+			inv.addChild("all", name)
+		}
+		if err := inv.parseYAMLGroup(name, root.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inv *inventory) parseYAMLGroup(name string, node *yaml.Node) error {
+	g := inv.group(name)
+	if node.Kind != yaml.MappingNode {
+		// A group without hosts, vars or children
+		return nil
+	}
+	if hosts := mappingValue(node, "hosts"); hosts != nil && hosts.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(hosts.Content); i += 2 {
+			inv.addHost(name, hosts.Content[i].Value, yamlVars(hosts.Content[i+1]))
+		}
+	}
+	for key, value := range yamlVars(mappingValue(node, "vars")) {
+		g.vars[key] = value
+	}
+	if children := mappingValue(node, "children"); children != nil && children.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(children.Content); i += 2 {
+			child := children.Content[i].Value
+			inv.addChild(name, child)
+			if err := inv.parseYAMLGroup(child, children.Content[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// yamlVars returns the variables of a mapping node as strings and lists of strings,
+// without templated or vault-encrypted values.
+func yamlVars(node *yaml.Node) map[string]any {
+	vars := make(map[string]any)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return vars
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch {
+		case value.Tag == "!vault":
+		case value.Kind == yaml.ScalarNode && inventoryValue(value.Value):
+			vars[key] = value.Value
+		case value.Kind == yaml.SequenceNode:
+			var list []string
+			for _, item := range value.Content {
+				if item.Kind == yaml.ScalarNode && inventoryValue(item.Value) {
+					list = append(list, item.Value)
+				}
+			}
+			vars[key] = list
+		}
+	}
+	return vars
+}
+
+// inventoryValue reports whether a value can be used as is, i.e. is not a template
+// or vault-encrypted.
+func inventoryValue(value string) bool {
+	return !strings.Contains(value, "{{") && !strings.HasPrefix(strings.TrimSpace(value), "$ANSIBLE_VAULT")
+}
+
+// parseINI reads an inventory in INI format.
+func (inv *inventory) parseINI(data []byte) error {
+	section, kind := "ungrouped", "hosts"
+	inv.addChild("all", "ungrouped")
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section, kind, _ = strings.Cut(text[1:len(text)-1], ":")
+			if kind == "" {
+				kind = "hosts"
+			}
+			if section != "all" {
+				inv.addChild("all", section)
+			}
+			continue
+		}
+		fields, err := splitINIFields(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		switch kind {
+		case "hosts":
+			vars := make(map[string]any)
+			for _, field := range fields[1:] {
+				key, value, ok := strings.Cut(field, "=")
+				if !ok {
+					return fmt.Errorf("line %d: expected key=value, got %s", line, field)
+				}
+				if inventoryValue(value) {
+					vars[key] = value
+				}
+			}
+			inv.addHost(section, fields[0], vars)
+		case "vars":
+			key, value, ok := strings.Cut(text, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected key=value, got %s", line, text)
+			}
+			value = strings.Trim(strings.TrimSpace(value), `'"`)
+			if inventoryValue(value) {
+				inv.group(section).vars[strings.TrimSpace(key)] = value
+			}
+		case "children":
+			inv.addChild(section, fields[0])
+		default:
+			return fmt.Errorf("line %d: unknown section type %s", line, kind)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitINIFields splits an inventory line into whitespace-separated fields,
+// removing the quotes around values.
+func splitINIFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inField = r, true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		case r == '#' && !inField:
+			// Comment after the fields
+			return fields, nil
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeInventory(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseCSV(t *testing.T) {
+	a := assert.New(t)
+
+	path := writeInventory(t, "cmdb.csv", "\ufeffName,URL,Username,Tags,TLS_Verify,Owner\n"+
+		"emea-prod,10.1.1.1,admin,emea;prod,true,Network team\n"+
+		",,,,,\n"+
+		"us-lab, 10.2.1.1 ,,us;lab,,\n")
+	fabrics, err := ParseCSV(path)
+	a.NoError(err)
+	a.Len(fabrics, 2)
+	a.Equal("emea-prod", fabrics[0].Name)
+	a.Equal("10.1.1.1", fabrics[0].URL)
+	a.Equal("admin", fabrics[0].Username)
+	a.Equal([]string{"emea", "prod"}, fabrics[0].Tags)
+	a.NotNil(fabrics[0].TLSVerify)
+	a.True(*fabrics[0].TLSVerify)
+	a.Equal("10.2.1.1", fabrics[1].URL)
+	a.Nil(fabrics[1].TLSVerify)
+
+	path = writeInventory(t, "cmdb.csv", "name,host\nemea,10.1.1.1\n")
+	_, err = ParseCSV(path)
+	a.ErrorContains(err, "has no url column")
+
+	path = writeInventory(t, "cmdb.csv", "name,url,page_size\nemea,10.1.1.1,many\n")
+	_, err = ParseCSV(path)
+	a.ErrorContains(err, path+":2:")
+
+	path = writeInventory(t, "cmdb.csv", "name,url\nemea,10.1.1.1\nemea,10.1.1.2\n")
+	_, err = ParseCSV(path)
+	a.ErrorContains(err, "duplicate")
+}
+
+func TestParseAnsibleInventoryYAML(t *testing.T) {
+	a := assert.New(t)
+
+	path := writeInventory(t, "hosts.yml", `
+all:
+  vars:
+    aci_username: admin
+    validate_certs: no
+  children:
+    apics:
+      vars:
+        aci_password: "{{ vault_aci_password }}"
+      children:
+        emea:
+          hosts:
+            apic-emea-1:
+              ansible_host: 10.1.1.1
+            apic-emea-2:
+              ansible_host: 10.1.2.1
+              aci_port: 8443
+              validate_certs: yes
+        us:
+          vars:
+            aci_username: us-admin
+          hosts:
+            apic-us-1:
+              aci_host: 10.2.1.1
+              password: !vault |
+                $ANSIBLE_VAULT;1.1;AES256
+                6231
+    switches:
+      hosts:
+        leaf1:
+`)
+	fabrics, err := ParseAnsibleInventory(path, "apics")
+	a.NoError(err)
+	a.Len(fabrics, 3)
+
+	a.Equal("apic-emea-1", fabrics[0].Name)
+	a.Equal("10.1.1.1", fabrics[0].URL)
+	a.Equal("admin", fabrics[0].Username)
+	a.Empty(fabrics[0].Password)
+	a.Equal([]string{"apics", "emea"}, fabrics[0].Tags)
+	a.NotNil(fabrics[0].TLSVerify)
+	a.False(*fabrics[0].TLSVerify)
+
+	// Host variables take precedence over group variables
+	a.Equal("10.1.2.1:8443", fabrics[1].URL)
+	a.True(*fabrics[1].TLSVerify)
+
+	// Inner groups take precedence over outer groups
+	a.Equal("10.2.1.1", fabrics[2].URL)
+	a.Equal("us-admin", fabrics[2].Username)
+	a.Empty(fabrics[2].Password)
+	a.Equal([]string{"apics", "us"}, fabrics[2].Tags)
+
+	fabrics, err = ParseAnsibleInventory(path, "")
+	a.NoError(err)
+	a.Len(fabrics, 4)
+	a.Equal("leaf1", fabrics[3].URL)
+
+	_, err = ParseAnsibleInventory(path, "apic")
+	a.EqualError(err, "group apic not found in "+path)
+}
+
+func TestParseAnsibleInventoryINI(t *testing.T) {
+	a := assert.New(t)
+
+	path := writeInventory(t, "hosts", `
+# APICs
+apic-lab ansible_host=10.9.1.1
+
+[emea]
+apic-emea-1 ansible_host=10.1.1.1 tags="['prod', 'dc1']"
+apic-emea-2 ansible_host=10.1.2.1 ansible_port=22 aci_port=4443 # standby
+apic-emea-3 ansible_host=10.1.3.1 ansible_port=22 port=22
+
+[emea:vars]
+aci_username = "emea-admin"
+aci_password={{ lookup('env', 'ACI_PASSWORD') }}
+
+[apics:children]
+emea
+`)
+	fabrics, err := ParseAnsibleInventory(path, "apics")
+	a.NoError(err)
+	a.Len(fabrics, 3)
+	a.Equal("apic-emea-1", fabrics[0].Name)
+	a.Equal("10.1.1.1", fabrics[0].URL)
+	a.Equal("emea-admin", fabrics[0].Username)
+	a.Empty(fabrics[0].Password)
+	a.Equal([]string{"prod", "dc1", "apics", "emea"}, fabrics[0].Tags)
+	a.Equal("10.1.2.1:4443", fabrics[1].URL)
+	// ansible_port is the SSH port
+	a.Equal("10.1.3.1", fabrics[2].URL)
+
+	fabrics, err = ParseAnsibleInventory(path, "ungrouped")
+	a.NoError(err)
+	a.Len(fabrics, 1)
+	a.Equal("10.9.1.1", fabrics[0].URL)
+	a.Empty(fabrics[0].Tags)
+
+	path = writeInventory(t, "hosts", "[emea]\napic-emea-1 ansible_host\n")
+	_, err = ParseAnsibleInventory(path, "")
+	a.ErrorContains(err, "line 2: expected key=value")
+}
+
+func TestWriteConfig(t *testing.T) {
+	a := assert.New(t)
+
+	verify := false
+	cfg := Config{Fabrics: []FabricConfig{
+		{Name: "emea", URL: "10.1.1.1", Tags: []string{"emea"}, TLSVerify: &verify},
+		{URL: "10.2.1.1"},
+	}}
+	var buf bytes.Buffer
+	a.NoError(cfg.Write(&buf))
+	a.Equal(`fabrics:
+  - name: emea
+    url: 10.1.1.1
+    tags:
+      - emea
+    tls_verify: false
+  - url: 10.2.1.1
+`, buf.String())
+
+	path := writeInventory(t, "config.yaml", buf.String())
+	parsed, err := ParseConfig(path)
+	a.NoError(err)
+	a.Len(parsed.Fabrics, 2)
+	a.Equal(cfg.Fabrics[0].Tags, parsed.Fabrics[0].Tags)
+	a.False(*parsed.Fabrics[0].TLSVerify)
+	a.Equal("10.2.1.1", parsed.Fabrics[1].URL)
+}
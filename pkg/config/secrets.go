@@ -0,0 +1,356 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// passwordCommandTimeout bounds the time a password command may take, e.g. to log in to a vault.
+const passwordCommandTimeout = 2 * time.Minute
+
+// envReference matches a ${NAME} reference to an environment variable.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// credentialField is a credential setting that may reference external secrets.
+type credentialField struct {
+	key   string
+	value *string
+}
+
+// credentials are the credential settings of the global config or a fabric.
+type credentials struct {
+	password        *string
+	passwordFile    *string
+	passwordCommand *string
+	fields          []credentialField
+}
+
+func (g *GlobalConfig) credentials() credentials {
+	return newCredentials(&g.Username, &g.Password, &g.PrivateKey, &g.CertName,
+		&g.PasswordFile, &g.PasswordCommand)
+}
+
+func (f *FabricConfig) credentials() credentials {
+	return newCredentials(&f.Username, &f.Password, &f.PrivateKey, &f.CertName,
+		&f.PasswordFile, &f.PasswordCommand)
+}
+
+func newCredentials(username, password, privateKey, certName, passwordFile, passwordCommand *string) credentials {
+	return credentials{
+		password:        password,
+		passwordFile:    passwordFile,
+		passwordCommand: passwordCommand,
+		fields: []credentialField{
+			{"username", username},
+			{"password", password},
+			{"private_key", privateKey},
+			{"cert_name", certName},
+			{"password_file", passwordFile},
+			{"password_command", passwordCommand},
+		},
+	}
+}
+
+// secretResolver reads passwords from the sources set in the config.
+type secretResolver struct {
+	// commands caches the output of password commands, run once each
+	commands map[string]string
+}
+
+// resolveSecrets expands ${NAME} environment references in the credential settings
+// and reads passwords from password_file and password_command.
+func (c *Config) resolveSecrets() error {
+	r := &secretResolver{commands: map[string]string{}}
+	if err := r.resolve(c.Global.credentials()); err != nil {
+		return fmt.Errorf("global: %w", err)
+	}
+	for i := range c.Fabrics {
+		if err := r.resolve(c.Fabrics[i].credentials()); err != nil {
+			return fmt.Errorf("fabric %s: %w", c.fabricLabel(i), err)
+		}
+	}
+	return nil
+}
+
+func (r *secretResolver) resolve(cred credentials) error {
+	for _, field := range cred.fields {
+		value, err := expandEnv(*field.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.key, err)
+		}
+		*field.value = value
+	}
+
+	sources := 0
+	for _, source := range []string{*cred.password, *cred.passwordFile, *cred.passwordCommand} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("set only one of password, password_file and password_command")
+	}
+
+	switch {
+	case *cred.passwordFile != "":
+		password, err := readPasswordFile(*cred.passwordFile)
+		if err != nil {
+			return fmt.Errorf("password_file: %w", err)
+		}
+		*cred.password = password
+	case *cred.passwordCommand != "":
+		password, ok := r.commands[*cred.passwordCommand]
+		if !ok {
+			var err error
+			password, err = runPasswordCommand(*cred.passwordCommand)
+			if err != nil {
+				return fmt.Errorf("password_command: %w", err)
+			}
+			r.commands[*cred.passwordCommand] = password
+		}
+		*cred.password = password
+	}
+	return nil
+}
+
+// expandEnv replaces ${NAME} references with the value of the environment variable.
+// Unlike os.ExpandEnv, a bare $ is kept, so passwords may contain it.
+func expandEnv(value string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return env
+	})
+	return expanded, err
+}
+
+// expandHome replaces a leading ~ with the home directory of the user.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// readSecretFile reads a file holding secrets, which must be a regular file
+// that only its owner can access.
+func readSecretFile(path string) ([]byte, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	// Windows has no permission bits to check
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users (mode %04o); restrict it with chmod 600",
+			path, info.Mode().Perm())
+	}
+	return os.ReadFile(path)
+}
+
+// readPasswordFile reads a password from the first line of a file.
+func readPasswordFile(path string) (string, error) {
+	data, err := readSecretFile(path)
+	if err != nil {
+		return "", err
+	}
+	password, _, _ := strings.Cut(string(data), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return password, nil
+}
+
+// runPasswordCommand runs a command in the shell and returns the first line of its output.
+// Its stdin and stderr are the collector's, so that it can ask to log in to a vault.
+func runPasswordCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, &stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("timed out after %s", passwordCommandTimeout)
+		}
+		return "", err
+	}
+	password, _, _ := strings.Cut(stdout.String(), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("command printed no password")
+	}
+	return password, nil
+}
+
+// netrcEntry is a machine entry of a netrc file.
+type netrcEntry struct {
+	machine  string // empty for the default entry
+	login    string
+	password string
+}
+
+// netrcPath returns the netrc file: $NETRC, otherwise ~/.netrc (or ~/_netrc on Windows).
+func netrcPath() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(home, ".netrc")
+	if runtime.GOOS == "windows" {
+		if _, err := os.Stat(path); err != nil {
+			path = filepath.Join(home, "_netrc")
+		}
+	}
+	return path, nil
+}
+
+// parseNetrc parses the machine and default entries of a netrc file.
+// Macro definitions are skipped.
+func parseNetrc(data []byte) []netrcEntry {
+	var entries []netrcEntry
+	var entry *netrcEntry
+	inMacro := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro definition ends with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			var value string
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: value})
+				entry = &entries[len(entries)-1]
+				i++
+			case "default":
+				entries = append(entries, netrcEntry{})
+				entry = &entries[len(entries)-1]
+			case "login":
+				if entry != nil {
+					entry.login = value
+				}
+				i++
+			case "password":
+				if entry != nil {
+					entry.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return entries
+}
+
+// lookupNetrc returns the first entry matching one of the APIC addresses and the
+// username, if set. Addresses are matched with and without port; the default entry
+// matches any address.
+func lookupNetrc(entries []netrcEntry, urls []string, username string) (netrcEntry, bool) {
+	for _, url := range urls {
+		host := strings.SplitN(url, "/", 2)[0]
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
+		}
+		for _, entry := range entries {
+			if entry.machine == "" || entry.password == "" {
+				continue
+			}
+			if !strings.EqualFold(entry.machine, host) && !strings.EqualFold(entry.machine, hostname) {
+				continue
+			}
+			if username == "" || entry.login == "" || entry.login == username {
+				return entry, true
+			}
+		}
+	}
+	for _, entry := range entries {
+		if entry.machine == "" && entry.password != "" &&
+			(username == "" || entry.login == "" || entry.login == username) {
+			return entry, true
+		}
+	}
+	return netrcEntry{}, false
+}
+
+// applyNetrc fills the username and password of fabrics using password authentication
+// that have no password yet from the netrc file, keyed by APIC address.
+func (c *Config) applyNetrc() error {
+	path, err := netrcPath()
+	if err != nil {
+		return fmt.Errorf("netrc: %w", err)
+	}
+	data, err := readSecretFile(path)
+	if err != nil {
+		return fmt.Errorf("netrc: %w", err)
+	}
+	entries := parseNetrc(data)
+	for i := range c.Fabrics {
+		fabric := &c.Fabrics[i]
+		if fabric.Password != "" || c.usesCertAuth(i) {
+			continue
+		}
+		username := fabric.Username
+		if username == "" {
+			username = c.Global.Username
+		}
+		if username != "" && username == c.Global.Username && c.Global.Password != "" {
+			continue
+		}
+		entry, ok := lookupNetrc(entries, fabric.GetURLs(), username)
+		if !ok {
+			continue
+		}
+		if username == "" {
+			fabric.Username = entry.login
+		}
+		fabric.Password = entry.password
+	}
+	return nil
+}
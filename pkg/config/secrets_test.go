@@ -0,0 +1,156 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnv(t *testing.T) {
+	a := assert.New(t)
+	t.Setenv("ACI_TEST_USER", "admin")
+	t.Setenv("ACI_TEST_EMPTY", "")
+
+	value, err := expandEnv("${ACI_TEST_USER}")
+	a.NoError(err)
+	a.Equal("admin", value)
+
+	value, err = expandEnv("ro-${ACI_TEST_USER}${ACI_TEST_EMPTY}")
+	a.NoError(err)
+	a.Equal("ro-admin", value)
+
+	// A bare $ is not a reference
+	value, err = expandEnv("pa$$word$HOME")
+	a.NoError(err)
+	a.Equal("pa$$word$HOME", value)
+
+	_, err = expandEnv("${ACI_TEST_UNSET}")
+	a.ErrorContains(err, "ACI_TEST_UNSET is not set")
+}
+
+func TestResolveSecrets(t *testing.T) {
+	a := assert.New(t)
+	tmpDir := t.TempDir()
+	passwordFile := filepath.Join(tmpDir, "password")
+	a.NoError(os.WriteFile(passwordFile, []byte("from-file\nignored\n"), 0o600))
+	t.Setenv("ACI_TEST_PASSWORD", "from-env")
+	t.Setenv("ACI_TEST_PASSWORD_FILE", passwordFile)
+
+	cfg := Config{
+		Global: GlobalConfig{Username: "admin", PasswordFile: "${ACI_TEST_PASSWORD_FILE}"},
+		Fabrics: []FabricConfig{
+			{Name: "one", URL: "10.1.1.1"},
+			{Name: "two", URL: "10.2.2.2", Username: "ro", Password: "${ACI_TEST_PASSWORD}"},
+			{Name: "three", URL: "10.3.3.3", Username: "ops", PasswordCommand: "echo from-command"},
+		},
+	}
+	a.NoError(cfg.NormalizeAndPrompt())
+	a.Equal("from-file", cfg.Fabrics[0].Password)
+	a.Equal("from-env", cfg.Fabrics[1].Password)
+	if runtime.GOOS != "windows" {
+		a.Equal("from-command", cfg.Fabrics[2].Password)
+	}
+
+	// Only one password source per fabric
+	cfg = Config{Fabrics: []FabricConfig{
+		{Name: "one", URL: "10.1.1.1", Username: "admin", Password: "secret", PasswordFile: passwordFile},
+	}}
+	a.ErrorContains(cfg.NormalizeAndPrompt(), "fabric one: set only one of password")
+
+	// Missing variables are reported with the setting
+	cfg = Config{
+		Global:  GlobalConfig{Username: "admin", Password: "${ACI_TEST_UNSET}"},
+		Fabrics: []FabricConfig{{Name: "one", URL: "10.1.1.1"}},
+	}
+	a.ErrorContains(cfg.NormalizeAndPrompt(), "global: password: environment variable ACI_TEST_UNSET is not set")
+
+	if runtime.GOOS != "windows" {
+		// A failing command is an error
+		cfg = Config{Fabrics: []FabricConfig{
+			{Name: "one", URL: "10.1.1.1", Username: "admin", PasswordCommand: "exit 3"},
+		}}
+		a.ErrorContains(cfg.NormalizeAndPrompt(), "fabric one: password_command: exit status 3")
+	}
+}
+
+func TestReadPasswordFile(t *testing.T) {
+	a := assert.New(t)
+	tmpDir := t.TempDir()
+
+	path := filepath.Join(tmpDir, "password")
+	a.NoError(os.WriteFile(path, []byte("secret\r\n"), 0o600))
+	password, err := readPasswordFile(path)
+	a.NoError(err)
+	a.Equal("secret", password)
+
+	a.NoError(os.WriteFile(path, []byte("\n"), 0o600))
+	_, err = readPasswordFile(path)
+	a.ErrorContains(err, "is empty")
+
+	_, err = readPasswordFile(tmpDir)
+	a.ErrorContains(err, "not a regular file")
+
+	if runtime.GOOS != "windows" {
+		a.NoError(os.WriteFile(path, []byte("secret\n"), 0o600))
+		a.NoError(os.Chmod(path, 0o644))
+		_, err = readPasswordFile(path)
+		a.ErrorContains(err, "accessible by other users (mode 0644)")
+	}
+}
+
+func TestNetrc(t *testing.T) {
+	a := assert.New(t)
+
+	entries := parseNetrc([]byte(`
+# lab fabrics
+machine apic1.lab login admin password lab-secret
+machine 10.1.1.1:8443
+  login ro
+  password ro-secret
+macdef init
+machine ignored login x password y
+
+machine 10.2.2.2 login admin account ops password prod-secret
+default login guest password guest-secret
+`))
+	a.Equal([]netrcEntry{
+		{machine: "apic1.lab", login: "admin", password: "lab-secret"},
+		{machine: "10.1.1.1:8443", login: "ro", password: "ro-secret"},
+		{machine: "10.2.2.2", login: "admin", password: "prod-secret"},
+		{login: "guest", password: "guest-secret"},
+	}, entries)
+
+	entry, ok := lookupNetrc(entries, []string{"APIC1.lab:443"}, "")
+	a.True(ok)
+	a.Equal("lab-secret", entry.password)
+
+	// Later APICs of a cluster are tried too
+	entry, ok = lookupNetrc(entries, []string{"10.2.2.1", "10.2.2.2"}, "admin")
+	a.True(ok)
+	a.Equal("prod-secret", entry.password)
+
+	// The login must match the username, if set
+	entry, ok = lookupNetrc(entries, []string{"10.2.2.2"}, "guest")
+	a.True(ok)
+	a.Equal("guest-secret", entry.password)
+	_, ok = lookupNetrc(entries, []string{"10.2.2.2"}, "nobody")
+	a.False(ok)
+
+	// Fabrics get credentials from the netrc file
+	if runtime.GOOS == "windows" {
+		return
+	}
+	path := filepath.Join(t.TempDir(), "netrc")
+	a.NoError(os.WriteFile(path, []byte("machine 10.1.1.1 login admin password netrc-secret\n"), 0o600))
+	t.Setenv("NETRC", path)
+	cfg := Config{
+		Global:  GlobalConfig{Netrc: true, NonInteractive: true},
+		Fabrics: []FabricConfig{{Name: "one", URL: "https://10.1.1.1"}},
+	}
+	a.NoError(cfg.NormalizeAndPrompt())
+	a.Equal("admin", cfg.Fabrics[0].Username)
+	a.Equal("netrc-secret", cfg.Fabrics[0].Password)
+}
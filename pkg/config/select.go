@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Selector picks fabrics of a config by name and tag with glob patterns, e.g. "emea-*".
+// A fabric is selected if its name matches one of Names or one of its tags matches
+// one of Tags, and none of its tags matches ExcludeTags. Without Names and Tags,
+// every fabric not excluded is selected.
+type Selector struct {
+	Names       []string
+	Tags        []string
+	ExcludeTags []string
+}
+
+// Empty reports whether the selector keeps every fabric.
+func (s Selector) Empty() bool {
+	return len(s.Names) == 0 && len(s.Tags) == 0 && len(s.ExcludeTags) == 0
+}
+
+// Select keeps the fabrics picked by the selector. A name or tag pattern matching
+// no fabric is an error, as it is most likely a typo.
+func (c *Config) Select(s Selector) error {
+	for _, pattern := range slices.Concat(s.Names, s.Tags, s.ExcludeTags) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range s.Names {
+		if !c.anyFabric(func(f FabricConfig) bool { return matchAny([]string{pattern}, f.GetFabricName()) }) {
+			return fmt.Errorf("no fabric named %s", pattern)
+		}
+	}
+	for _, pattern := range s.Tags {
+		if !c.anyFabric(func(f FabricConfig) bool { return matchAny([]string{pattern}, f.Tags...) }) {
+			return fmt.Errorf("no fabric tagged %s", pattern)
+		}
+	}
+
+	var selected []FabricConfig
+	for _, fabric := range c.Fabrics {
+		included := len(s.Names) == 0 && len(s.Tags) == 0 ||
+			matchAny(s.Names, fabric.GetFabricName()) || matchAny(s.Tags, fabric.Tags...)
+		if included && !matchAny(s.ExcludeTags, fabric.Tags...) {
+			selected = append(selected, fabric)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no fabric left to collect after excluding tags %s", strings.Join(s.ExcludeTags, ", "))
+	}
+	c.Fabrics = selected
+	return nil
+}
+
+func (c *Config) anyFabric(match func(FabricConfig) bool) bool {
+	for _, fabric := range c.Fabrics {
+		if match(fabric) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether one of the values matches one of the glob patterns.
+// Patterns are validated by Select.
+func matchAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
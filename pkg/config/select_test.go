@@ -0,0 +1,61 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	a := assert.New(t)
+
+	inventory := func() Config {
+		return Config{Fabrics: []FabricConfig{
+			{Name: "emea-prod", URL: "10.1.1.1", Tags: []string{"emea", "prod"}},
+			{Name: "emea-lab", URL: "10.1.2.1", Tags: []string{"emea", "lab"}},
+			{Name: "us-prod", URL: "10.2.1.1", Tags: []string{"us", "prod"}},
+			{URL: "10.3.1.1"},
+		}}
+	}
+	names := func(cfg Config) []string {
+		var names []string
+		for _, fabric := range cfg.Fabrics {
+			names = append(names, fabric.GetFabricName())
+		}
+		return names
+	}
+
+	cfg := inventory()
+	a.NoError(cfg.Select(Selector{}))
+	a.Len(cfg.Fabrics, 4)
+
+	cfg = inventory()
+	a.NoError(cfg.Select(Selector{Names: []string{"us-prod"}}))
+	a.Equal([]string{"us-prod"}, names(cfg))
+
+	// Fabrics without a name are selected by url
+	cfg = inventory()
+	a.NoError(cfg.Select(Selector{Names: []string{"10.3.*"}}))
+	a.Equal([]string{"10.3.1.1"}, names(cfg))
+
+	cfg = inventory()
+	a.NoError(cfg.Select(Selector{Tags: []string{"emea"}}))
+	a.Equal([]string{"emea-prod", "emea-lab"}, names(cfg))
+
+	// Names and tags add up, excluded tags are removed
+	cfg = inventory()
+	a.NoError(cfg.Select(Selector{Names: []string{"us-*"}, Tags: []string{"emea"}, ExcludeTags: []string{"lab"}}))
+	a.Equal([]string{"emea-prod", "us-prod"}, names(cfg))
+
+	cfg = inventory()
+	a.NoError(cfg.Select(Selector{ExcludeTags: []string{"p*"}}))
+	a.Equal([]string{"emea-lab", "10.3.1.1"}, names(cfg))
+
+	cfg = inventory()
+	a.EqualError(cfg.Select(Selector{Names: []string{"emea-prd"}}), "no fabric named emea-prd")
+	a.EqualError(cfg.Select(Selector{Tags: []string{"apac"}}), "no fabric tagged apac")
+	a.EqualError(cfg.Select(Selector{Tags: []string{"lab"}, ExcludeTags: []string{"emea"}}),
+		"no fabric left to collect after excluding tags emea")
+	a.ErrorContains(cfg.Select(Selector{Names: []string{"[emea"}}), `invalid pattern "[emea"`)
+	a.Len(cfg.Fabrics, 4)
+}
@@ -0,0 +1,456 @@
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"collector/pkg/aci"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is the JSON Schema of the config file, for editors to validate it.
+//
+//go:embed config.schema.json
+var Schema []byte
+
+// Problem is an invalid setting of a config file. Line and Column are 0 when
+// the problem has no position, e.g. for fabrics that aren't read from a file.
+type Problem struct {
+	Path    string
+	Line    int
+	Column  int
+	Message string
+	// fabric is the index of the fabric the problem belongs to, or -1
+	fabric int
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Line > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", p.Path, p.Line, p.Column, p.Message)
+	case p.Path != "":
+		return p.Path + ": " + p.Message
+	}
+	return p.Message
+}
+
+// ValidationError lists every problem found in a config.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.String()
+	}
+	return strings.Join(problems, "; ")
+}
+
+// Validate checks a config file as ParseConfig does, plus the fabric names,
+// without prompting or resolving secrets. The error is a *ValidationError
+// listing every problem if the file could be parsed as YAML.
+func Validate(path string) (*Config, error) {
+	cfg, doc, err := parseConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	err = validateConfig(cfg, false)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		return cfg, err
+	}
+	fabrics := mappingValue(doc.Content[0], "fabrics")
+	for i, problem := range invalid.Problems {
+		problem.Path = path
+		if fabrics != nil && problem.fabric >= 0 && problem.fabric < len(fabrics.Content) {
+			problem.Line, problem.Column = fabrics.Content[problem.fabric].Line, fabrics.Content[problem.fabric].Column
+		}
+		invalid.Problems[i] = problem
+	}
+	return cfg, invalid
+}
+
+// Check runs the settingChecks on the settings of a config, e.g. once command line
+// flags and environment variables, which don't go through ParseConfig, were
+// applied. The error is a *ValidationError listing every problem.
+func (c *Config) Check() error {
+	problems := checkSettings("global", -1, c.Global)
+	for i, fabric := range c.Fabrics {
+		problems = append(problems, checkSettings("fabric "+c.fabricLabel(i), i, fabric)...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// checkSettings runs the settingChecks on the set fields of a GlobalConfig or FabricConfig.
+func checkSettings(label string, fabric int, settings any) []Problem {
+	var problems []Problem
+	v := reflect.ValueOf(settings)
+	for i := range v.NumField() {
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		check := settingChecks[key]
+		field := v.Field(i)
+		if check == nil || field.Kind() == reflect.Pointer && field.IsNil() {
+			continue
+		}
+		field = reflect.Indirect(field)
+		values := []reflect.Value{field}
+		if field.Kind() == reflect.Slice {
+			values = nil
+			for j := range field.Len() {
+				values = append(values, field.Index(j))
+			}
+		}
+		for _, value := range values {
+			if err := check(value.Interface()); err != nil {
+				problems = append(problems, Problem{Message: fmt.Sprintf("%s: %s %v", label, key, err), fabric: fabric})
+			}
+		}
+	}
+	return problems
+}
+
+// yamlFields maps the YAML keys of the fields of a struct to their types.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := range t.NumField() {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key != "" && key != "-" {
+			fields[key] = t.Field(i).Type
+		}
+	}
+	return fields
+}
+
+var (
+	configSections = yamlFields(reflect.TypeFor[Config]())
+	globalSettings = yamlFields(reflect.TypeFor[GlobalConfig]())
+)
+
+// settingChecks validate setting values beyond their type. Checks of list
+// settings are applied to each item.
+var settingChecks = map[string]func(value any) error{
+	"url":                     checkURL,
+	"urls":                    checkURL,
+	"tls_min_version":         checkTLSVersion,
+	"request_retry_count":     notNegative,
+	"retry_delay":             notNegative,
+	"max_retry_delay":         notNegative,
+	"batch_size":              positive,
+	"max_concurrency":         positive,
+	"max_requests_per_second": notNegative,
+	"page_size":               positive,
+	"deadline":                notNegative,
+	"max_parallel_fabrics":    notNegative,
+	"request_budget":          notNegative,
+	"fabric_start_delay":      notNegative,
+}
+
+// checker collects the problems of a config document.
+type checker struct {
+	problems []Problem
+	seen     map[*yaml.Node]bool
+}
+
+func (c *checker) add(node *yaml.Node, format string, args ...any) {
+	c.problems = append(c.problems, Problem{
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+		fabric:  -1,
+	})
+}
+
+// checkDocument checks the keys and values of a config document.
+func checkDocument(doc *yaml.Node) []Problem {
+	c := &checker{seen: make(map[*yaml.Node]bool)}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := resolveAlias(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		c.add(root, "config file must be a mapping with global and fabrics")
+		return c.problems
+	}
+	c.mapping(root, func(key, value *yaml.Node) {
+		switch key.Value {
+		case "global":
+			c.settings(value, "global", globalSettings)
+		case "fabrics":
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+				return
+			}
+			if value.Kind != yaml.SequenceNode {
+				c.add(value, "fabrics must be a list")
+				return
+			}
+			for _, fabric := range value.Content {
+				c.settings(fabric, "fabric", fabricSettings)
+			}
+		default:
+			c.unknown(key, "", configSections)
+		}
+	})
+	slices.SortStableFunc(c.problems, func(a, b Problem) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	return c.problems
+}
+
+// mapping calls fn for each key of a mapping, following merge keys, and reports
+// keys set twice.
+func (c *checker) mapping(node *yaml.Node, fn func(key, value *yaml.Node)) {
+	if c.seen[node] {
+		// A mapping merged into several others is checked once
+		return
+	}
+	c.seen[node] = true
+	keys := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveAlias(node.Content[i+1])
+		if key.Value == "<<" {
+			merged := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				merged = value.Content
+			}
+			for _, m := range merged {
+				if m = resolveAlias(m); m.Kind == yaml.MappingNode {
+					c.mapping(m, fn)
+				}
+			}
+			continue
+		}
+		if first, ok := keys[key.Value]; ok {
+			c.add(key, "%s is set twice, first at line %d", key.Value, first.Line)
+			continue
+		}
+		keys[key.Value] = key
+		fn(key, value)
+	}
+}
+
+// settings checks a mapping of global or fabric settings.
+func (c *checker) settings(node *yaml.Node, section string, known map[string]reflect.Type) {
+	node = resolveAlias(node)
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" && section == "global" {
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		c.add(node, "%s must be a mapping of settings", section)
+		return
+	}
+	c.mapping(node, func(key, value *yaml.Node) {
+		t, ok := known[key.Value]
+		if !ok {
+			c.unknown(key, section, known)
+			return
+		}
+		c.value(key.Value, t, value)
+	})
+}
+
+// unknown reports an unknown key, suggesting the setting most likely meant.
+func (c *checker) unknown(key *yaml.Node, section string, known map[string]reflect.Type) {
+	name := key.Value
+	switch {
+	case section == "fabric" && globalSettings[name] != nil:
+		c.add(key, "%s can only be set in global", name)
+		return
+	case section == "global" && fabricSettings[name] != nil:
+		c.add(key, "%s can only be set per fabric", name)
+		return
+	}
+	what := "setting"
+	if section == "" {
+		what = "section"
+	}
+	if suggestion := suggestKey(name, known); suggestion != "" {
+		c.add(key, "unknown %s %s, did you mean %s?", what, name, suggestion)
+		return
+	}
+	c.add(key, "unknown %s %s", what, name)
+}
+
+// value checks the value of a setting against its type and settingChecks.
+func (c *checker) value(key string, t reflect.Type, node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	nodes := []*yaml.Node{node}
+	if t.Kind() == reflect.Slice {
+		if node.Kind != yaml.SequenceNode {
+			c.add(node, "%s must be a list", key)
+			return
+		}
+		t, nodes = t.Elem(), node.Content
+	}
+	for _, item := range nodes {
+		item = resolveAlias(item)
+		value := reflect.New(t)
+		if err := item.Decode(value.Interface()); err != nil {
+			c.add(item, "%s must be %s, not %s", key, describeType(t), describeNode(item))
+			continue
+		}
+		check := settingChecks[key]
+		if check == nil {
+			continue
+		}
+		v := value.Elem()
+		for v.Kind() == reflect.Pointer && !v.IsNil() {
+			v = v.Elem()
+		}
+		if err := check(v.Interface()); err != nil {
+			c.add(item, "%s %v", key, err)
+		}
+	}
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// describeType describes the values of a setting type for error messages.
+func describeType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[time.Duration]() {
+		return "a duration such as 30s or 90m"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Map:
+		return "a mapping"
+	}
+	return t.String()
+}
+
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return strconv.Quote(node.Value)
+}
+
+// suggestKey returns the known key closest to an unknown one, e.g. batch_size
+// for batchsize or retry-delay, or "" if none is close.
+func suggestKey(key string, known map[string]reflect.Type) string {
+	simplify := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "", " ", "").Replace(s))
+	}
+	best, bestDistance := "", 3
+	for _, candidate := range slices.Sorted(maps.Keys(known)) {
+		distance := editDistance(simplify(key), simplify(candidate))
+		if distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func positive(value any) error {
+	if number(value) <= 0 {
+		return fmt.Errorf("must be greater than 0, not %v", value)
+	}
+	return nil
+}
+
+func notNegative(value any) error {
+	if number(value) < 0 {
+		return fmt.Errorf("must not be negative, not %v", value)
+	}
+	return nil
+}
+
+func number(value any) float64 {
+	v := reflect.ValueOf(value)
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanFloat():
+		return v.Float()
+	}
+	return 0
+}
+
+// checkURL checks an APIC address: a hostname or IP address with an optional
+// https:// or http:// scheme and port, 443 by default.
+func checkURL(value any) error {
+	address, _ := value.(string)
+	if address == "" {
+		// Prompted for
+		return nil
+	}
+	invalid := fmt.Errorf("must be an APIC hostname or IP address such as apic1.example.com or 10.0.0.1:8443, not %q", address)
+	host := normalizeURL(address)
+	if strings.Contains(host, "://") {
+		return invalid
+	}
+	u, err := url.Parse("https://" + host)
+	if err != nil || u.Hostname() == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return invalid
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return invalid
+		}
+	}
+	return nil
+}
+
+func checkTLSVersion(value any) error {
+	version, _ := value.(string)
+	if _, err := aci.ParseTLSVersion(version); err != nil {
+		return fmt.Errorf("must be 1.0, 1.1, 1.2 or 1.3, not %q", version)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfigStrict(t *testing.T) {
+	a := assert.New(t)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	a.NoError(os.WriteFile(configPath, []byte(`global:
+  batchsize: 3
+  retry-delay: 5
+  page_size: 0
+  deadline: 30
+  url: 10.0.0.1
+fabric:
+  - url: 10.0.0.1
+fabrics:
+  - name: emea
+    url: 10.1.1.1:99999
+    batch_size: -1
+    tls_min_version: "1.4"
+    resume: true
+  - url: https://10.2.1.1
+    urls: [10.2.1.2, "apic 3"]
+    verbose: maybe
+    verbose: true
+`), 0644))
+
+	_, err := ParseConfig(configPath)
+	var invalid *ValidationError
+	a.ErrorAs(err, &invalid)
+	var problems []string
+	for _, problem := range invalid.Problems {
+		problems = append(problems, problem.String())
+	}
+	a.Equal([]string{
+		configPath + ":2:3: unknown setting batchsize, did you mean batch_size?",
+		configPath + ":3:3: unknown setting retry-delay, did you mean retry_delay?",
+		configPath + ":4:14: page_size must be greater than 0, not 0",
+		configPath + ":5:13: deadline must be a duration such as 30s or 90m, not \"30\"",
+		configPath + ":6:3: url can only be set per fabric",
+		configPath + ":7:1: unknown section fabric, did you mean fabrics?",
+		configPath + ":11:10: url must be an APIC hostname or IP address such as apic1.example.com or 10.0.0.1:8443, not \"10.1.1.1:99999\"",
+		configPath + ":12:17: batch_size must be greater than 0, not -1",
+		configPath + ":13:22: tls_min_version must be 1.0, 1.1, 1.2 or 1.3, not \"1.4\"",
+		configPath + ":14:5: resume can only be set in global",
+		configPath + ":16:22: urls must be an APIC hostname or IP address such as apic1.example.com or 10.0.0.1:8443, not \"apic 3\"",
+		configPath + ":17:14: verbose must be true or false, not \"maybe\"",
+		configPath + ":18:5: verbose is set twice, first at line 17",
+	}, problems)
+}
+
+func TestValidate(t *testing.T) {
+	a := assert.New(t)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	a.NoError(os.WriteFile(configPath, []byte(`defaults: &defaults
+  username: admin
+  batch_size: 3
+fabrics:
+  - <<: *defaults
+    url: 10.1.1.1
+  - <<: *defaults
+    name: 10.1.1.1
+  - name: lab
+`), 0644))
+
+	// Anchors must be defined in a known section
+	_, err := Validate(configPath)
+	a.EqualError(err, configPath+":1:1: unknown section defaults")
+
+	a.NoError(os.WriteFile(configPath, []byte(`global:
+  username: admin
+fabrics:
+  - &emea
+    url: 10.1.1.1
+    batch_size: 3
+  - <<: *emea
+    name: 10.1.1.1
+  - name: lab
+`), 0644))
+	cfg, err := Validate(configPath)
+	a.EqualError(err, configPath+":7:5: duplicate fabric name/url: 10.1.1.1")
+	a.Len(cfg.Fabrics, 3)
+	a.Equal(3, *cfg.Fabrics[1].BatchSize)
+
+	// Missing URLs are prompted for
+	a.NoError(os.WriteFile(configPath, []byte("fabrics:\n  - name: lab\n"), 0644))
+	_, err = Validate(configPath)
+	a.NoError(err)
+}
+
+func TestCheck(t *testing.T) {
+	a := assert.New(t)
+
+	cfg := New()
+	cfg.Fabrics = []FabricConfig{{Name: "emea", URL: "10.1.1.1"}, {URL: "10.2.1.1", URLs: []string{"10.2.1.2"}}}
+	a.NoError(cfg.Check())
+
+	zero, negative := 0, -1
+	cfg.Global.RequestBudget = -1
+	cfg.Global.FabricStartDelay = -time.Second
+	cfg.Fabrics[0].BatchSize = &zero
+	cfg.Fabrics[0].RetryDelay = &negative
+	cfg.Fabrics[1].URLs = []string{"apic 2"}
+	var invalid *ValidationError
+	a.ErrorAs(cfg.Check(), &invalid)
+	a.Equal(`global: request_budget must not be negative, not -1; `+
+		`global: fabric_start_delay must not be negative, not -1s; `+
+		`fabric emea: retry_delay must not be negative, not -1; `+
+		`fabric emea: batch_size must be greater than 0, not 0; `+
+		`fabric 10.2.1.1: urls must be an APIC hostname or IP address such as apic1.example.com or 10.0.0.1:8443, not "apic 2"`,
+		invalid.Error())
+}
+
+func TestCheckURL(t *testing.T) {
+	a := assert.New(t)
+
+	for _, url := range []string{"", "10.0.0.1", "apic1.example.com", "https://apic1:8443", "http://10.0.0.1/", "[2001:db8::1]:443"} {
+		a.NoError(checkURL(url), url)
+	}
+	for _, url := range []string{"ftp://apic1", "apic1/api", "admin@apic1", "apic1:0", "apic1:https", "apic 1", "https://"} {
+		a.Error(checkURL(url), url)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	a := assert.New(t)
+
+	var schema struct {
+		Properties struct {
+			Global struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"global"`
+		} `json:"properties"`
+		Defs struct {
+			Fabric struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"fabric"`
+		} `json:"$defs"`
+	}
+	a.NoError(json.Unmarshal(Schema, &schema))
+
+	// Every setting is in the schema
+	a.Equal(slices.Sorted(maps.Keys(globalSettings)), slices.Sorted(maps.Keys(schema.Properties.Global.Properties)))
+	a.Equal(slices.Sorted(maps.Keys(fabricSettings)), slices.Sorted(maps.Keys(schema.Defs.Fabric.Properties)))
+}
@@ -2,22 +2,25 @@
 package log
 
 import (
+	"bytes"
 	"io"
 	"os"
 	"runtime"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"github.com/rs/zerolog/pkgerrors"
+	"github.com/tidwall/gjson"
 )
 
-const logFile = "aci-vetr.log"
-
 // Logger aliases the zerolog.Logger
 type Logger = zerolog.Logger
 
 var (
+	out    = &output{console: zerolog.ConsoleWriter{Out: os.Stderr, NoColor: runtime.GOOS == "windows"}}
 	logger = New()
 
 	// Convenience shortcuts for logging levels
@@ -30,24 +33,109 @@ var (
 	With  = logger.With
 )
 
-// New creates a new multi-level logger
+// New creates a new multi-level logger writing to the console, the log file and captures
 func New() Logger {
 	if testing.Testing() {
 		return zerolog.Nop()
 	}
-	// If filename is specified, open file and assume file logging
-	file, err := os.Create(logFile)
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to create log file")
+	// Levels default to zero, i.e. debug
+	return zerolog.New(out).With().Timestamp().Logger()
+}
+
+// fileWriter formats log events for files, with dates for reading the log later.
+func fileWriter(w io.Writer) io.Writer {
+	return zerolog.ConsoleWriter{Out: w, NoColor: true, TimeFormat: time.RFC3339}
+}
+
+// output writes each log event to the console, the log file and the captures it belongs to.
+type output struct {
+	mu       sync.Mutex
+	console  io.Writer
+	file     *os.File
+	fileOut  io.Writer
+	captures []*Capture
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.console.Write(p)
+	if o.fileOut != nil {
+		o.fileOut.Write(p)
+	}
+	if len(o.captures) > 0 {
+		fabric := gjson.GetBytes(p, "fabric").Str
+		for _, c := range o.captures {
+			if c.fabric == "" || c.fabric == fabric {
+				c.out.Write(p)
+			}
+		}
 	}
+	return len(p), nil
+}
 
-	// Levels default to zero, i.e. debug
-	return zerolog.New(
+// SetFile writes the log to the given file, replacing any previous log file.
+// An empty path stops writing the log to a file.
+func SetFile(path string) error {
+	return SetFileFrom(path, nil)
+}
+
+// SetFileFrom is like SetFile, but stops the capture c and starts the file with
+// the events it recorded, e.g. those logged before the log file was known.
+func SetFileFrom(path string, c *Capture) error {
+	var file *os.File
+	if path != "" {
+		var err error
+		file, err = os.Create(path)
+		if err != nil {
+			if c != nil {
+				c.Stop()
+			}
+			return err
+		}
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if c != nil {
+		out.captures = slices.DeleteFunc(out.captures, func(other *Capture) bool { return other == c })
+		if file != nil {
+			file.Write(c.buf.Bytes())
+		}
+	}
+	if out.file != nil {
+		out.file.Close()
+	}
+	out.file, out.fileOut = file, nil
+	if file != nil {
+		out.fileOut = fileWriter(file)
+	}
+	return nil
+}
+
+// Capture records log events in memory, e.g. to add the log of a fabric to its archive.
+type Capture struct {
+	fabric string
+	buf    bytes.Buffer
+	out    io.Writer
+}
+
+// StartCapture starts recording the log events of a fabric, identified by the fabric
+// field set by WithFabric. An empty fabric records all events.
+func StartCapture(fabric string) *Capture {
+	c := &Capture{fabric: fabric}
+	c.out = fileWriter(&c.buf)
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.captures = append(out.captures, c)
+	return c
+}
 
-		zerolog.ConsoleWriter{
-			Out:     io.MultiWriter(os.Stderr, file),
-			NoColor: runtime.GOOS == "windows",
-		}).With().Timestamp().Logger()
+// Stop stops recording and returns the log recorded.
+func (c *Capture) Stop() []byte {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.captures = slices.DeleteFunc(out.captures, func(other *Capture) bool { return other == c })
+	return c.buf.Bytes()
 }
 
 func init() {
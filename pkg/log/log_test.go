@@ -0,0 +1,55 @@
+package log
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture(t *testing.T) {
+	a := assert.New(t)
+	out.console = io.Discard
+	logger := zerolog.New(out)
+
+	// Captures record the events of their fabric, or all events
+	one := StartCapture("one")
+	all := StartCapture("")
+	logger.Info().Str("fabric", "one").Msg("first")
+	logger.Info().Str("fabric", "two").Msg("second")
+	logger.Info().Msg("third")
+	oneLog, allLog := string(one.Stop()), string(all.Stop())
+	a.Contains(oneLog, "first")
+	a.NotContains(oneLog, "second")
+	a.NotContains(oneLog, "third")
+	a.Contains(allLog, "first")
+	a.Contains(allLog, "second")
+	a.Contains(allLog, "third")
+
+	// Stopped captures record nothing
+	logger.Info().Str("fabric", "one").Msg("fourth")
+	a.NotContains(string(one.Stop()), "fourth")
+	a.Empty(out.captures)
+}
+
+func TestSetFileFrom(t *testing.T) {
+	a := assert.New(t)
+	out.console = io.Discard
+	logger := zerolog.New(out)
+	path := filepath.Join(t.TempDir(), "collector.log")
+
+	// Events captured before the log file is opened are written to it
+	early := StartCapture("")
+	logger.Error().Msg("early")
+	a.NoError(SetFileFrom(path, early))
+	logger.Info().Msg("late")
+	a.Empty(out.captures)
+	a.NoError(SetFile(""))
+
+	data, err := os.ReadFile(path)
+	a.NoError(err)
+	a.Regexp("(?s)early.*late", string(data))
+}
@@ -3,22 +3,33 @@ package req
 
 //go:generate go run ../../cmd/genscript/main.go
 
-import "collector/pkg/aci"
+import (
+	"time"
+
+	"collector/pkg/aci"
+)
 
 // Mod modifies an aci Request
 type Mod = func(*aci.Req)
 
 // Request is an HTTP request.
+// Zero retry settings use the fabric's configuration.
 type Request struct {
-	Class string            // MO class
-	Query map[string]string // Query parameters
+	Class         string            // MO class
+	Query         map[string]string // Query parameters
+	PageSize      int               // Objects per page, 0 fetches the class in a single request
+	Count         int               // Objects counted by preflight, 0 if unknown
+	RetryCount    int               // Times to retry a failed request, -1 for no retries
+	Timeout       time.Duration     // Timeout of each attempt
+	RetryDelay    time.Duration     // Delay before the first retry, doubled for each further retry
+	MaxRetryDelay time.Duration     // Upper bound of the retry delay
 }
 
 // Requests contains all the ACI API requests to execute
 var Requests = []Request{
 	{Class: "faultInst"},
 	{Class: "eqptFlash"},
-	{Class: "topSystem"},
+	{Class: "topSystem", Timeout: 30 * time.Second},
 	{Class: "isisDomPol"},
 	{Class: "fabricSetupP"},
 	{Class: "eqptStorage"},
@@ -95,6 +106,7 @@ var Requests = []Request{
 		Query: map[string]string{
 			"rsp-subtree-include": "count",
 		},
+		Timeout: 2 * time.Minute,
 	},
 	{Class: "vzFilter"},
 	{Class: "fabricCtrlrConfigP"},